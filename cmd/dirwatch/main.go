@@ -0,0 +1,194 @@
+// Command dirwatch watches directories, printing the files that change and/or
+// running a command whenever some do:
+//
+//	dirwatch -r -p '*.go' -i 500ms . -- go test ./...
+//
+// The directories (the current one if none are given) come after the flags,
+// and the command after "--". Without a command, the changes are printed one
+// per line (or as JSON, with -json); with one, only with -v. Runs of the
+// command never overlap: changes that come in during a run start another once
+// it's done, or with -restart, kill it and start again right away.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/laumann/goutil/directorywatcher"
+)
+
+// What the command line asks for
+type config struct {
+	dirs    []string
+	command []string
+
+	recursive bool
+	patterns  listFlag
+	exts      string
+	ignores   listFlag
+	gitignore bool
+	depth     int
+	interval  time.Duration
+	debounce  time.Duration
+	native    bool
+	initial   bool
+	restart   bool
+	pathsArgs bool
+	verbose   bool
+	json      bool
+}
+
+// A flag that can be given more than once
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func parse(args []string, output io.Writer) (*config, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("dirwatch", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprintln(output, "Usage: dirwatch [flags] [dir ...] [-- command [arg ...]]")
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&cfg.recursive, "r", false, "watch the whole tree below the directories")
+	fs.Var(&cfg.patterns, "p", "only watch files matching this glob (repeatable, any of them)")
+	fs.StringVar(&cfg.exts, "e", "", "only watch files with these comma-separated extensions")
+	fs.Var(&cfg.ignores, "x", "ignore what matches this gitignore-style pattern (repeatable)")
+	fs.BoolVar(&cfg.gitignore, "gitignore", false, "ignore what the directory's .gitignore does")
+	fs.IntVar(&cfg.depth, "depth", 0, "with -r, only watch this many levels down (0 for no limit)")
+	fs.DurationVar(&cfg.interval, "i", 0, "scan interval (default 2s)")
+	fs.DurationVar(&cfg.debounce, "d", 0, "wait until a file has been quiet this long")
+	fs.BoolVar(&cfg.native, "native", false, "use the operating system's notifications instead of scanning")
+	fs.BoolVar(&cfg.initial, "initial", false, "report the files already there, running the command once at the start")
+	fs.BoolVar(&cfg.restart, "restart", false, "kill a running command when files change, instead of waiting for it")
+	fs.BoolVar(&cfg.pathsArgs, "a", false, "pass the changed paths as extra arguments to the command")
+	fs.BoolVar(&cfg.verbose, "v", false, "print the changes even when running a command")
+	fs.BoolVar(&cfg.json, "json", false, "print the changes as JSON, a line per batch")
+
+	// Split off the command first: the flag package would take "--" as the
+	// end of the flags, and the command for directories.
+	for i, arg := range args {
+		if arg == "--" {
+			args, cfg.command = args[:i], args[i+1:]
+			if len(cfg.command) == 0 {
+				err := errors.New("No command after --")
+				fmt.Fprintln(output, err)
+				fs.Usage()
+				return nil, err
+			}
+			break
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.dirs = fs.Args()
+	if len(cfg.dirs) == 0 {
+		cfg.dirs = []string{"."}
+	}
+	return cfg, nil
+}
+
+// The options for the watcher, except for the extra directories and the
+// ignore patterns, which are added to it afterwards.
+func (cfg *config) options() []directorywatcher.Option {
+	opts := []directorywatcher.Option{directorywatcher.WithRecursive(cfg.recursive)}
+	if len(cfg.patterns) > 0 {
+		opts = append(opts, directorywatcher.WithPatterns(cfg.patterns...))
+	}
+	if cfg.exts != "" {
+		opts = append(opts, directorywatcher.WithExtensions(strings.Split(cfg.exts, ",")...))
+	}
+	if cfg.gitignore {
+		opts = append(opts, directorywatcher.WithGitignore())
+	}
+	if cfg.depth != 0 {
+		opts = append(opts, directorywatcher.WithMaxDepth(cfg.depth))
+	}
+	if cfg.interval != 0 {
+		opts = append(opts, directorywatcher.WithInterval(cfg.interval))
+	}
+	if cfg.debounce != 0 {
+		opts = append(opts, directorywatcher.WithDebounce(cfg.debounce))
+	}
+	if cfg.native {
+		opts = append(opts, directorywatcher.WithBackend(directorywatcher.Native))
+	}
+	if !cfg.initial {
+		opts = append(opts, directorywatcher.WithPreload())
+	}
+	return opts
+}
+
+func main() {
+	cfg, err := parse(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2) // parse has said why
+	}
+	dw, err := directorywatcher.New(cfg.dirs[0], cfg.options()...)
+	if err != nil {
+		fail(err)
+	}
+	for _, dir := range cfg.dirs[1:] {
+		if err := dw.AddPath(dir); err != nil {
+			fail(err)
+		}
+	}
+	if err := dw.Ignore(cfg.ignores...); err != nil {
+		fail(err)
+	}
+	dw.SetErrorHandler(func(err error) {
+		fmt.Fprintln(os.Stderr, "dirwatch:", err)
+	})
+
+	if len(cfg.command) == 0 || cfg.verbose {
+		format := directorywatcher.TextLines
+		if cfg.json {
+			format = directorywatcher.JSONLines
+		}
+		dw.AddWriter(os.Stdout, format)
+	}
+	if len(cfg.command) > 0 {
+		cmd := directorywatcher.Command{
+			Name:        cfg.command[0],
+			Args:        cfg.command[1:],
+			PathsAsArgs: cfg.pathsArgs,
+			PathsInEnv:  true,
+		}
+		if cfg.restart {
+			cmd.Mode = directorywatcher.Restart
+		}
+		stop := dw.ExecCommand(cmd)
+		defer stop()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	dw.StartContext(ctx)
+	<-ctx.Done()
+	dw.StopAndWait()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "dirwatch:", err)
+	os.Exit(1)
+}
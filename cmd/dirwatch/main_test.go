@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/laumann/goutil/directorywatcher"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := parse([]string{"-r", "-p", "*.go", "-p", "go.mod", "-i", "500ms", "-x", "vendor/", "-x", "*.tmp", "src", "lib", "--", "go", "test", "./..."}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.recursive || fmt.Sprint(cfg.patterns) != "[*.go go.mod]" || cfg.interval != 500*time.Millisecond {
+		t.Errorf("flags not parsed: %+v", cfg)
+	}
+	if fmt.Sprint(cfg.ignores) != "[vendor/ *.tmp]" {
+		t.Errorf("expected both ignore patterns, got %v", cfg.ignores)
+	}
+	if fmt.Sprint(cfg.dirs) != "[src lib]" || fmt.Sprint(cfg.command) != "[go test ./...]" {
+		t.Errorf("unexpected dirs %v and command %v", cfg.dirs, cfg.command)
+	}
+
+	cfg, err = parse([]string{"--", "make"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(cfg.dirs) != "[.]" || fmt.Sprint(cfg.command) != "[make]" {
+		t.Errorf("expected the current directory and make, got %v and %v", cfg.dirs, cfg.command)
+	}
+
+	if _, err := parse([]string{".", "--"}, io.Discard); err == nil {
+		t.Error("expected an error for a missing command")
+	}
+	if _, err := parse([]string{"-i", "soon"}, io.Discard); err == nil {
+		t.Error("expected an error for an invalid interval")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := parse([]string{"-p", "*.go", "-i", "10ms", "-initial", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw, err := directorywatcher.New(cfg.dirs[0], cfg.options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	select {
+	case evAt := <-c:
+		if len(evAt.Events) != 1 || filepath.Base(evAt.Events[0].Path) != "a.go" {
+			t.Errorf("expected only a.go, got %v", evAt.Events)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no events")
+	}
+}
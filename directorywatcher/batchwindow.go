@@ -0,0 +1,75 @@
+package directorywatcher
+
+import "time"
+
+// Adds the events of a scan to the ones held back by BatchWindow, and returns
+// all of them once the window has elapsed or MaxBatchSize events are held.
+// Successive events on the same path are collapsed as by Debounce. Without a
+// BatchWindow (and nothing held), events pass straight through.
+func (dw *directoryWatcher) hold(now time.Time, events []Event) []Event {
+	if dw.BatchWindow <= 0 && len(dw.held) == 0 {
+		return events
+	}
+	dw.collect(events)
+	if len(dw.held) == 0 {
+		dw.heldSince = time.Time{}
+		return nil
+	}
+	if dw.heldSince.IsZero() {
+		dw.heldSince = now
+	}
+	full := dw.MaxBatchSize > 0 && len(dw.held) >= dw.MaxBatchSize
+	if !full && now.Before(dw.windowEnd()) {
+		return nil
+	}
+	return dw.release()
+}
+
+// Adds events to the held ones, collapsing them with those on the same path.
+func (dw *directoryWatcher) collect(events []Event) {
+	if dw.held == nil {
+		dw.held = make(map[string]Event)
+	}
+	collapseInto(dw.held, events)
+}
+
+// Adds events to those in m, by path, collapsing them as by Debounce.
+func collapseInto(m map[string]Event, events []Event) {
+	for _, ev := range events {
+		if prev, ok := m[ev.OldPath]; ok && ev.Type == Renamed {
+			delete(m, ev.OldPath)
+			ev = followRename(prev, ev)
+		}
+		path := ev.Path
+		if prev, ok := m[path]; ok {
+			var keep bool
+			if ev, keep = coalesce(prev, ev); !keep {
+				delete(m, path)
+				continue
+			}
+		}
+		delete(m, path)
+		m[ev.Path] = ev
+	}
+}
+
+// When the events held back by BatchWindow are due.
+func (dw *directoryWatcher) windowEnd() time.Time {
+	return dw.heldSince.Add(dw.BatchWindow)
+}
+
+// Returns, and forgets, every event held back by BatchWindow.
+func (dw *directoryWatcher) release() []Event {
+	dw.heldSince = time.Time{}
+	return takeAll(dw.held)
+}
+
+// Empties m, returning what was in it.
+func takeAll(m map[string]Event) []Event {
+	events := make([]Event, 0, len(m))
+	for path, ev := range m {
+		events = append(events, ev)
+		delete(m, path)
+	}
+	return events
+}
@@ -0,0 +1,90 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBatchWindow(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Interval = 100
+	dw.BatchWindow = time.Second
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	<-dw.Ready()
+
+	// A burst of changes over several ticks, then quiet ticks
+	then := time.Now().Add(-time.Hour)
+	a, b, tmp := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), filepath.Join(dir, "tmp.txt")
+	burst := map[int]func(){
+		1: func() { writeFile(t, a, "a", then) },
+		2: func() { writeFile(t, tmp, "tmp", then) },
+		3: func() { writeFile(t, b, "b", then) },
+		4: func() {
+			if err := os.Remove(tmp); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	var got []EventsAt
+	for i := 1; i <= 20; {
+		if change, ok := burst[i]; ok {
+			change()
+			delete(burst, i)
+		}
+		select {
+		case ft.c <- fc.now.Add(time.Duration(i) * 100 * time.Millisecond):
+			i++
+		case evAt := <-c:
+			got = append(got, evAt)
+		}
+	}
+	select {
+	case evAt := <-c:
+		got = append(got, evAt)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected a single batch, got %v", got)
+	}
+	events := got[0].Events
+	if len(events) != 2 || events[0].Path != a || events[1].Path != b {
+		t.Fatalf("expected a.txt and b.txt to be added, got %v", events)
+	}
+	for _, ev := range events {
+		if ev.Type != Added {
+			t.Errorf("expected Added event, got %v", ev)
+		}
+	}
+}
+
+func TestMaxBatchSize(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	dw.BatchWindow = time.Hour
+	dw.MaxBatchSize = 3
+	now := time.Now()
+	info := fakeInfo{name: "a", size: 1}
+
+	if got := dw.hold(now, []Event{{Added, "a", info, nil, ""}, {Added, "b", info, nil, ""}}); got != nil {
+		t.Errorf("released %v before the batch was full", got)
+	}
+	// Collapses into the held Added event, so the batch isn't full yet
+	if got := dw.hold(now, []Event{{Changed, "a", info, info, ""}}); got != nil {
+		t.Errorf("released %v before the batch was full", got)
+	}
+	if got := dw.hold(now, []Event{{Deleted, "c", info, info, ""}}); len(got) != 3 {
+		t.Errorf("expected the full batch of 3 events, got %v", got)
+	}
+	if len(dw.held) != 0 {
+		t.Errorf("events still held after release: %v", dw.held)
+	}
+}
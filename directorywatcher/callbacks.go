@@ -0,0 +1,137 @@
+package directorywatcher
+
+import "sync"
+
+// Calls fn with every batch, from a goroutine of its own, as an alternative to
+// reading from an observer channel. Batches are handed over one at a time, so
+// the watcher waits for fn like it would for an observer (see DeliveryTimeout
+// and AddObserverPolicy for not waiting). Calling the returned stop detaches
+// fn; a call already in progress still finishes.
+func (dw *directoryWatcher) OnEvent(fn func(EventsAt)) (stop func()) {
+	o := NewObserver()
+	dw.AddObserver(o)
+	return dw.run(o, fn)
+}
+
+// Calls fn with every Added event, see OnEvent.
+func (dw *directoryWatcher) OnAdded(fn func(Event)) (stop func()) {
+	return dw.onEach(fn, Added)
+}
+
+// Calls fn with every Changed event, see OnEvent.
+func (dw *directoryWatcher) OnChanged(fn func(Event)) (stop func()) {
+	return dw.onEach(fn, Changed)
+}
+
+// Calls fn with every Deleted event, see OnEvent.
+func (dw *directoryWatcher) OnDeleted(fn func(Event)) (stop func()) {
+	return dw.onEach(fn, Deleted)
+}
+
+func (dw *directoryWatcher) onEach(fn func(Event), types ...eventType) (stop func()) {
+	o := NewObserver()
+	dw.AddObserverFiltered(o, types...)
+	return dw.run(o, func(evAt EventsAt) {
+		for _, ev := range evAt.Events {
+			fn(ev)
+		}
+	})
+}
+
+// Calls fn with the events of every batch, from a goroutine of its own, as
+// OnEvent does, but without the watcher waiting for fn: what comes in while fn
+// runs is collected, merging the events on each path as Debounce does, and
+// passed to the next call. So a slow fn misses nothing, and is called less
+// often. Calling the returned stop detaches fn; once it returns, fn isn't
+// called anymore.
+func (dw *directoryWatcher) OnEventsCoalesced(fn func([]Event)) (stop func()) {
+	var mu sync.Mutex // Guards pending
+	pending := make(map[string]Event)
+	wake, quit := make(chan struct{}, 1), make(chan struct{})
+	o := NewObserver()
+	dw.AddObserver(o)
+	stopRun := dw.run(o, func(evAt EventsAt) {
+		mu.Lock()
+		for _, ev := range evAt.Events {
+			path := ev.Path
+			if prev, ok := pending[path]; ok {
+				var keep bool
+				if ev, keep = coalesce(prev, ev); !keep {
+					delete(pending, path)
+					continue
+				}
+			}
+			pending[path] = ev
+		}
+		mu.Unlock()
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+
+	var calling sync.Mutex // Held while fn runs, so stop can wait for it
+	go func() {
+		for {
+			select {
+			case <-wake:
+			case <-quit:
+				return
+			}
+			mu.Lock()
+			events := make([]Event, 0, len(pending))
+			for path, ev := range pending {
+				events = append(events, ev)
+				delete(pending, path)
+			}
+			mu.Unlock()
+			if len(events) == 0 {
+				continue
+			}
+			sortEvents(events)
+			calling.Lock()
+			select {
+			case <-quit:
+			default:
+				fn(events)
+			}
+			calling.Unlock()
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stopRun()
+			calling.Lock()
+			close(quit)
+			calling.Unlock()
+		})
+	}
+}
+
+// Hands the batches arriving on o to fn until stop is called (or o is closed
+// by UnsubscribeAll).
+func (dw *directoryWatcher) run(o Observer, fn func(EventsAt)) (stop func()) {
+	quit := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case evAt, ok := <-o:
+				if !ok {
+					return
+				}
+				fn(evAt)
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			dw.RemoveObserver(o)
+			close(quit)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package directorywatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallbacks(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	batches := make(chan EventsAt, 10)
+	added, deleted := make(chan Event, 10), make(chan Event, 10)
+	stopAll := dw.OnEvent(func(evAt EventsAt) { batches <- evAt })
+	dw.OnAdded(func(ev Event) { added <- ev })
+	stopDeleted := dw.OnDeleted(func(ev Event) { deleted <- ev })
+
+	info := fakeInfo{name: "a", size: 1}
+	dw.send(EventsAt{ScanSeq: 1, Events: []Event{{Added, "a", info, nil, ""}, {Changed, "b", info, info, ""}}})
+	if evAt := receiveWithin(t, batches); len(evAt.Events) != 2 {
+		t.Errorf("expected the whole batch, got %v", evAt.Events)
+	}
+	if ev := receiveWithin(t, added); ev.Path != "a" {
+		t.Errorf("expected the Added event for a, got %v", ev)
+	}
+
+	stopAll()
+	stopAll() // Harmless
+	stopDeleted()
+	if n := dw.ObserverCount(); n != 1 {
+		t.Errorf("expected only the OnAdded observer left, got %d", n)
+	}
+	dw.send(EventsAt{ScanSeq: 2, Events: []Event{{Deleted, "a", info, info, ""}}})
+	select {
+	case ev := <-deleted:
+		t.Errorf("stopped callback called with %v", ev)
+	case evAt := <-batches:
+		t.Errorf("stopped callback called with %v", evAt)
+	case <-time.After(50 * time.Millisecond):
+	}
+	dw.UnsubscribeAll(true)
+}
+
+func TestOnEventsCoalesced(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	calls, release := make(chan []Event, 10), make(chan struct{})
+	stop := dw.OnEventsCoalesced(func(events []Event) {
+		calls <- events
+		<-release
+	})
+	info := fakeInfo{name: "a", size: 1}
+	dw.send(EventsAt{ScanSeq: 1, Events: []Event{{Added, "a", info, nil, ""}}})
+	receiveWithin(t, calls)
+
+	// Sent while fn runs, and passed on in one call once it returns
+	dw.send(EventsAt{ScanSeq: 2, Events: []Event{{Added, "b", info, nil, ""}, {Changed, "a", info, info, ""}}})
+	dw.send(EventsAt{ScanSeq: 3, Events: []Event{{Deleted, "b", info, info, ""}, {Added, "c", info, nil, ""}}})
+	dw.send(EventsAt{ScanSeq: 4}) // Taken once the previous batches are collected
+	release <- struct{}{}
+	events := receiveWithin(t, calls)
+	if len(events) != 2 || events[0].Path != "a" || events[0].Type != Changed || events[1].Path != "c" {
+		t.Errorf("expected a as Changed and c, with b cancelled out, got %v", events)
+	}
+	close(release)
+	stop()
+	if n := dw.ObserverCount(); n != 0 {
+		t.Errorf("observer still attached after stop: %d", n)
+	}
+}
+
+func receiveWithin[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback not called")
+	}
+	var zero T
+	return zero
+}
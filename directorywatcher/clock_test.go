@@ -0,0 +1,222 @@
+package directorywatcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A clock whose tickers only tick when told to. Every ticker created is handed
+// to the test over the tickers channel.
+type fakeClock struct {
+	now     time.Time
+	tickers chan *fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC),
+		tickers: make(chan *fakeTicker, 1),
+	}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// Timers never fire on the fake clock; tests flush by ticking instead.
+func (c *fakeClock) After(d time.Duration) <-chan time.Time { return nil }
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	t := &fakeTicker{d: d, c: make(chan time.Time), resets: make(chan time.Duration, 16)}
+	c.tickers <- t
+	return t
+}
+
+// The ticker of a fakeClock. Every Reset is recorded on the resets channel.
+type fakeTicker struct {
+	d      time.Duration
+	c      chan time.Time
+	resets chan time.Duration
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time { return t.c }
+func (t *fakeTicker) Reset(d time.Duration)  { t.resets <- d }
+func (t *fakeTicker) Stop()                  {}
+
+// Waits for the watcher to create its ticker.
+func (c *fakeClock) ticker(t *testing.T) *fakeTicker {
+	t.Helper()
+	select {
+	case ft := <-c.tickers:
+		return ft
+	case <-time.After(time.Second):
+		t.Fatal("watcher never created a ticker")
+	}
+	return nil
+}
+
+// Ticks once and returns the interval the watcher reset the ticker to.
+func (ft *fakeTicker) tick(t *testing.T, at time.Time) time.Duration {
+	t.Helper()
+	ft.c <- at
+	select {
+	case d := <-ft.resets:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("ticker was not reset after a scan")
+	}
+	return 0
+}
+
+// Keeps ticking until the watcher delivers a batch to c.
+func (ft *fakeTicker) tickUntil(t *testing.T, at time.Time, c Observer) EventsAt {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ft.c <- at:
+		case evAt := <-c:
+			return evAt
+		case <-timeout:
+			t.Fatal("timed out waiting for events")
+		}
+	}
+}
+
+func TestAdaptiveInterval(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.AdaptiveInterval = true
+	dw.MinInterval = 100
+	dw.MaxInterval = 500
+	dw.AddObserver(make(Observer, 1))
+	dw.Start()
+
+	ft := fc.ticker(t)
+	if ft.d != 100*time.Millisecond {
+		t.Errorf("expected ticker to start at the minimum interval, got %s", ft.d)
+	}
+
+	// Idle: the interval keeps doubling until it hits the maximum
+	for _, want := range []time.Duration{200, 400, 500, 500} {
+		if got := ft.tick(t, fc.now); got != want*time.Millisecond {
+			t.Errorf("idle scan: expected interval %dms, got %s", want, got)
+		}
+	}
+
+	// Activity resets to the minimum
+	writeFile(t, filepath.Join(dir, "new.txt"), "x", fc.now)
+	if got := ft.tick(t, fc.now); got != 100*time.Millisecond {
+		t.Errorf("expected interval to reset on activity, got %s", got)
+	}
+	if got := ft.tick(t, fc.now); got != 200*time.Millisecond {
+		t.Errorf("expected backing off again once idle, got %s", got)
+	}
+}
+
+func TestFixedIntervalNotReset(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Interval = 300
+	dw.Start()
+
+	ft := fc.ticker(t)
+	if ft.d != 300*time.Millisecond {
+		t.Errorf("expected ticker to use Interval, got %s", ft.d)
+	}
+	ft.c <- fc.now
+	ft.c <- fc.now // only accepted once the first scan has completed
+	select {
+	case d := <-ft.resets:
+		t.Errorf("fixed interval ticker was reset to %s", d)
+	default:
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Interval = 250
+	dw.HeartbeatEvery = time.Second
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+
+	// Tick every 250ms for two seconds, collecting whatever is delivered
+	var got []time.Duration
+	for i := 1; i <= 8; {
+		select {
+		case ft.c <- fc.now.Add(time.Duration(i) * 250 * time.Millisecond):
+			i++
+		case evAt := <-c:
+			if len(evAt.Events) != 0 {
+				t.Errorf("unexpected events in heartbeat: %v", evAt.Events)
+			}
+			got = append(got, evAt.At.Sub(fc.now))
+		}
+	}
+	got = append(got, receive(t, c).At.Sub(fc.now))
+
+	want := []time.Duration{0, time.Second, 2 * time.Second}
+	if len(got) != len(want) {
+		t.Fatalf("expected heartbeats at %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected heartbeats at %v, got %v", want, got)
+			break
+		}
+	}
+}
+
+func TestNoHeartbeatByDefault(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	fc := newFakeClock()
+	dw.clock = fc
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case ft.c <- fc.now.Add(time.Duration(i) * time.Hour):
+		case evAt := <-c:
+			t.Fatalf("unexpected batch from an idle watcher: %+v", evAt)
+		}
+	}
+}
+
+func TestSetInterval(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	fc := newFakeClock()
+	dw.clock = fc
+	if err := dw.SetInterval(300 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	if ft.d != 300*time.Millisecond {
+		t.Errorf("expected ticker to use the interval set before Start, got %s", ft.d)
+	}
+
+	if err := dw.SetInterval(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-ft.resets:
+		if d != 5*time.Second {
+			t.Errorf("expected ticker to be reset to 5s, got %s", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ticker not reset by SetInterval")
+	}
+	if err := dw.SetInterval(0); err == nil {
+		t.Error("expected an error for a zero interval")
+	}
+}
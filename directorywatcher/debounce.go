@@ -0,0 +1,107 @@
+package directorywatcher
+
+import "time"
+
+// An event held back by Debounce, until it is due
+type pendingEvent struct {
+	ev  Event
+	due time.Time
+}
+
+// Adds the events of a scan to the pending ones and returns whichever are due
+// at now. With no Debounce (and nothing pending), events pass straight
+// through.
+func (dw *directoryWatcher) debounce(now time.Time, events []Event) (due []Event) {
+	if dw.Debounce <= 0 && len(dw.pending) == 0 {
+		return events
+	}
+	for _, ev := range events {
+		if p, ok := dw.pending[ev.OldPath]; ok && ev.Type == Renamed {
+			delete(dw.pending, ev.OldPath)
+			ev = followRename(p.ev, ev)
+		}
+		path := ev.Path
+		if p, ok := dw.pending[path]; ok {
+			var keep bool
+			if ev, keep = coalesce(p.ev, ev); !keep {
+				delete(dw.pending, path)
+				continue
+			}
+		}
+		// Not necessarily on path anymore, see coalesce
+		delete(dw.pending, path)
+		dw.pending[ev.Path] = pendingEvent{ev, now.Add(dw.Debounce)}
+	}
+	for path, p := range dw.pending {
+		if !p.due.After(now) {
+			due = append(due, p.ev)
+			delete(dw.pending, path)
+		}
+	}
+	return
+}
+
+// Delivers every pending (or held, or rate limited) event, due or not, as the
+// watcher is stopping.
+func (dw *directoryWatcher) flushPending() {
+	if len(dw.pending) == 0 && len(dw.held) == 0 && len(dw.limited) == 0 {
+		return
+	}
+	events := make([]Event, 0, len(dw.pending))
+	for path, p := range dw.pending {
+		events = append(events, p.ev)
+		delete(dw.pending, path)
+	}
+	dw.collect(events)
+	events = dw.release()
+	if dw.limited != nil {
+		collapseInto(dw.limited, events) // The limited ones came first
+		events = takeAll(dw.limited)
+	}
+	dw.notify(dw.newBatch(dw.clock.Now(), events))
+}
+
+// Fires when the next pending event (or the held batch, or the rate limited
+// one) is due, or never if nothing is waiting.
+func (dw *directoryWatcher) flushTimer(now time.Time) <-chan time.Time {
+	var next time.Time
+	for _, p := range dw.pending {
+		if next.IsZero() || p.due.Before(next) {
+			next = p.due
+		}
+	}
+	if len(dw.held) > 0 && (next.IsZero() || dw.windowEnd().Before(next)) {
+		next = dw.windowEnd()
+	}
+	if end := dw.limitEnd(); !end.IsZero() && (next.IsZero() || end.Before(next)) {
+		next = end
+	}
+	if next.IsZero() {
+		return nil
+	}
+	return dw.clock.After(next.Sub(now))
+}
+
+// Collapses two successive events on the same path into one, which describes
+// the change from before prev to after next. If the two cancel out (a file
+// that came and went), keep is false. A file that was renamed and then deleted
+// comes out as deleted from where it was before.
+func coalesce(prev, next Event) (ev Event, keep bool) {
+	switch {
+	case prev.Type == Added && next.Type == Deleted:
+		return Event{}, false
+	case prev.Type == Added:
+		next.Type, next.OldInfo, next.OldPath = Added, nil, ""
+	case prev.Type == Deleted && next.Type == Added:
+		next.Type, next.OldInfo = Changed, prev.OldInfo
+	case prev.Type == Renamed && next.Type == Deleted:
+		next.Path, next.OldInfo = prev.OldPath, prev.OldInfo
+	case prev.Type == Renamed:
+		next.Type, next.OldInfo, next.OldPath = Renamed, prev.OldInfo, prev.OldPath
+	case next.Type == AttrChanged && (prev.Type == Changed || prev.Type == Truncated):
+		next.Type, next.OldInfo = prev.Type, prev.OldInfo // Still changed
+	default:
+		next.OldInfo = prev.OldInfo
+	}
+	return next, true
+}
@@ -0,0 +1,160 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "a", then)
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Interval = 100
+	dw.Debounce = time.Second
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	receive(t, c) // The baseline isn't debounced
+
+	// Three quick writes, then quiet ticks for two seconds
+	var got []EventsAt
+	for i := 1; i <= 20; {
+		if i <= 3 {
+			// In the future, so a scan between write and Chtimes can't
+			// see a newer modtime than the final one
+			writeFile(t, path, strings.Repeat("a", i+1), then.Add(time.Duration(i)*time.Hour))
+		}
+		select {
+		case ft.c <- fc.now.Add(time.Duration(i) * 100 * time.Millisecond):
+			i++
+		case evAt := <-c:
+			got = append(got, evAt)
+		}
+	}
+	select {
+	case evAt := <-c:
+		got = append(got, evAt)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("expected a single coalesced batch, got %v", got)
+	}
+	ev := onlyEvent(t, got[0].Events)
+	if ev.Type != Changed || ev.Size() != 4 || ev.OldInfo.Size() != 1 {
+		t.Errorf("expected one Changed event from size 1 to 4, got %v (%d -> %d)", ev, ev.OldInfo.Size(), ev.Size())
+	}
+	if wait := got[0].At.Sub(fc.now); wait < time.Second {
+		t.Errorf("event delivered after %s, before the debounce window closed", wait)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	old := fakeInfo{name: "a", size: 1}
+	mid := fakeInfo{name: "a", size: 2}
+	cur := fakeInfo{name: "a", size: 3}
+	tests := []struct {
+		prev, next Event
+		want       eventType
+		keep       bool
+		oldInfo    os.FileInfo
+	}{
+		{Event{Added, "a", mid, nil, ""}, Event{Changed, "a", cur, mid, ""}, Added, true, nil},
+		{Event{Added, "a", mid, nil, ""}, Event{Deleted, "a", mid, mid, ""}, 0, false, nil},
+		{Event{Changed, "a", mid, old, ""}, Event{Changed, "a", cur, mid, ""}, Changed, true, old},
+		{Event{Changed, "a", mid, old, ""}, Event{Deleted, "a", mid, mid, ""}, Deleted, true, old},
+		{Event{Deleted, "a", old, old, ""}, Event{Added, "a", cur, nil, ""}, Changed, true, old},
+		{Event{Renamed, "a", mid, old, "z"}, Event{Changed, "a", cur, mid, ""}, Renamed, true, old},
+		{Event{Renamed, "a", mid, old, "z"}, Event{Deleted, "a", mid, mid, ""}, Deleted, true, old},
+		{Event{Changed, "a", mid, old, ""}, Event{AttrChanged, "a", cur, mid, ""}, Changed, true, old},
+	}
+	for _, test := range tests {
+		ev, keep := coalesce(test.prev, test.next)
+		if keep != test.keep {
+			t.Errorf("%v, %v: expected keep=%v", test.prev, test.next, test.keep)
+			continue
+		}
+		if keep && (ev.Type != test.want || ev.OldInfo != test.oldInfo) {
+			t.Errorf("%v, %v: expected %s with OldInfo %v, got %v with %v", test.prev, test.next, test.want, test.oldInfo, ev, ev.OldInfo)
+		}
+	}
+}
+
+func TestStopFlushesDebouncedEvents(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "a", then)
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Debounce = time.Hour
+	c := dw.AddNewObserver()
+	dw.Start()
+	ft := fc.ticker(t)
+	receive(t, c)
+
+	writeFile(t, path, "aa", then.Add(time.Minute))
+	// The second tick is only accepted once the first scan is done
+	ft.c <- fc.now.Add(time.Second)
+	ft.c <- fc.now.Add(2 * time.Second)
+	done := dw.done
+	dw.Stop()
+
+	ev := onlyEvent(t, receive(t, c).Events)
+	if ev.Type != Changed || ev.Size() != 2 {
+		t.Errorf("expected the pending Changed event, got %v", ev)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("watcher goroutine did not exit after Stop()")
+	}
+}
+
+func TestStopAndWait(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	then := time.Now().Add(-time.Hour)
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Preload = true
+	dw.Debounce = time.Hour
+	c := dw.AddNewBufferedObserver(4, Block)
+	dw.Start()
+	ft := fc.ticker(t)
+	<-dw.Ready()
+
+	writeFile(t, path, "a", then)
+	ft.c <- fc.now.Add(time.Second)
+	ft.c <- fc.now.Add(2 * time.Second)
+	dw.StopAndWait()
+	select {
+	case <-dw.exited():
+	default:
+		t.Fatal("watcher goroutine still running after StopAndWait()")
+	}
+	select {
+	case evAt := <-c:
+		if ev := onlyEvent(t, evAt.Events); ev.Type != Added {
+			t.Errorf("expected the pending Added event, got %v", ev)
+		}
+	default:
+		t.Error("pending event not delivered by StopAndWait()")
+	}
+	if len(c) != 0 {
+		t.Errorf("unexpected batches after StopAndWait(): %d", len(c))
+	}
+}
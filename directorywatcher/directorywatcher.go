@@ -1,67 +1,315 @@
 package directorywatcher
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultScanBuffer = 64
+	errorBuffer       = 16
+)
+
 // Type of observer function - adding an observer means adding a function of this type
 type Observer chan EventsAt
 
 // The directory watcher struct - note that the struct is not exported
 // (disallowing manual construct), but certain fields are (so we can set them
 // after creation).
+//
+// The exported fields are to be set before Start, and so are the Ignore
+// patterns and extra directories of AddPath, which return an error while the
+// watcher is running. Apart from that, the methods are safe to call from
+// several goroutines, also while the watcher is running.
 type directoryWatcher struct {
 	Interval  uint64 // interval in ms
 	Recursive bool   // Use filepath.Walk or filepath.Glob?
-	Pattern   string // glob pattern
+	Pattern   string // glob pattern, see included()
+
+	// Watch the files matching any of Patterns, instead of Pattern, if it's
+	// not empty, e.g. []string{"*.go", "go.mod", "Makefile"}.
+	Patterns []string
+
+	// Descend into symlinked directories when scanning recursively, and
+	// watch symlinked files by what they point to (as by os.Stat) rather than
+	// by the link itself (os.Lstat). Without it, symlinks to directories are
+	// left out.
+	FollowSymlinks bool
+
+	// How many files the scanner may find ahead of them being compared
+	ScanBuffer int
+
+	// When Recursive is set and MaxDepth is non-zero, only watch files up
+	// to MaxDepth levels down: 1 is just the files directly in the directory
+	// (as without Recursive), 2 adds those in its subdirectories, and so on.
+	// Deeper directories aren't scanned at all.
+	MaxDepth int
+
+	// Report directories below the watched one as Added and Deleted, just
+	// like files (but never as Changed). Their events can be told apart by
+	// IsDir(). Directories aren't subject to Pattern or WithExtensions, and
+	// are counted in TotalFiles.
+	ReportDirectories bool
+
+	// Report a file that was renamed (or moved within the watched tree)
+	// between two scans as Renamed, with OldPath set, instead of as Deleted
+	// and Added. A file is recognized by its size and modtime, and inode where
+	// there are inodes, so one that was also written to meanwhile still comes
+	// out as Deleted and Added.
+	DetectRenames bool
+
+	// Scan recursively with ScanWorkers goroutines at a time (GOMAXPROCS if
+	// zero), finding files in the same order as a sequential scan. Not used
+	// together with FollowSymlinks.
+	ParallelScan bool
+	ScanWorkers  int
+
+	// Scan recursively, but only list the directories whose modtime changed
+	// since the previous scan; what was found in the others is stat'ed
+	// directly. On large trees that hardly change, this saves reading most of
+	// the directories. Not used together with FollowSymlinks or ParallelScan.
+	IncrementalScan bool
 
 	// Internal details
-	scan      scanFn                 // The installed scanning function
+	scan      ScanFunc               // The installed scanning function
+	custom    bool                   // Whether scan was set by WithScanner, rather than chosen from the fields
 	path      string                 // the path being watched
+	absPath   string                 // path, made absolute in New
+	roots     []rootDir              // Every watched directory, path first, see AddPath
+	missing   map[string]bool        // Roots already reported as removed
 	files     map[string]os.FileInfo // Map of files watched
-	ticker    *time.Ticker           // The interval timer - if the ticker is != nil, then we assume that it's started
-	observers []Observer             // List of observers
+	clock     clock                  // Source of time and tickers
+	ticker    ticker                 // The interval timer - if the ticker is != nil, then we assume that it's started
+	native    io.Closer              // Stops the native backend, if that is what's running
+	cancel    context.CancelFunc     // Cancels the context the watcher was started with
+	done      chan struct{}          // Closed when the goroutine of the last Start() has exited
+	ready     chan struct{}          // Closed when the first scan after Start() is done, see Ready()
+	runMu     sync.Mutex             // Guards ticker, native, cancel, done and ready
+	observers []*subscriber          // List of observers, by descending priority
+	obsMu     sync.RWMutex           // Guards observers, which is replaced rather than modified
+	scanSeq   uint64                 // Number of scans performed
+	paused    int32                  // Set by Pause(), accessed atomically
+	wake      chan struct{}          // Signalled by Resume(), to deliver what was missed
+	missed    map[string]Event       // Events collected while paused
+	intervals chan uint64            // Set by SetInterval() for the running watcher
 
 	// Extra features
+
+	// With Preload, the files found by the first scan (as Initial events) are
+	// not delivered.
 	Preload bool
+
+	// With Preload, deliver the files found by the first scan as a single
+	// batch marked as Baseline (even if the directory is empty), instead of
+	// not delivering them at all.
+	PreloadSnapshot bool
+
+	// Backend selects how changes are discovered. Native uses inotify on
+	// Linux, ReadDirectoryChangesW on Windows and FSEvents on macOS (when
+	// built with cgo), and falls back to Polling elsewhere, or if it can't be
+	// started.
+	Backend Backend
+
+	// With AdaptiveInterval, the interval starts out at MinInterval and is
+	// doubled after every scan that found no changes, up to MaxInterval. A
+	// scan with changes resets it to MinInterval. Both bounds are in ms; a
+	// zero MinInterval means Interval.
+	AdaptiveInterval bool
+	MinInterval      uint64
+	MaxInterval      uint64
+
+	// Report a Changed event when a file's permission bits change, even if
+	// its modification time didn't. The old and new mode can be read from the
+	// event's OldInfo and FileInfo.
+	DetectModeChanges bool
+
+	// Report an AttrChanged event when a file's permission bits, owner or
+	// group change while its contents (modification time and size) stay
+	// the same, as by chmod or chown. Owners are only known where files
+	// have them. This takes precedence over DetectModeChanges.
+	DetectAttrChanges bool
+
+	// Report a Changed event when a file's modification time goes backwards
+	// (restored from a backup, rsync preserving older timestamps), not just
+	// when it moves forward.
+	DetectModtimeRegression bool
+
+	// Report a Changed event when a file's size changes, even if its
+	// modification time didn't (coarse timestamps, or tools that restore
+	// them).
+	DetectSizeChanges bool
+
+	// When set, decides whether a file that was seen before has changed,
+	// instead of comparing modtimes (and whatever the Detect fields above
+	// add): by size, by hashing the file at path, and so on. DetectTruncation
+	// and DetectAttrChanges still apply. It is called from the watcher's
+	// goroutine for every file at every scan, so it should be quick.
+	Comparator func(old, new os.FileInfo, path string) bool
+
+	// How the paths of events are reported: AsScanned (the default) joins
+	// them to the directory as it was passed to New, so they're absolute only
+	// if it was.
+	PathMode PathMode
+
+	// What to do when the watched directory itself is removed (all of them,
+	// see AddPath). Either way, its files are reported as Deleted and
+	// ErrRootRemoved is reported on Errors(). Then the watcher stops, or with
+	// WaitForRoot, keeps checking for the directory to come back and reports
+	// its files as Added when it does. The Native backend always stops.
+	WaitForRoot bool
+
+	// Report a Truncated event instead of Changed when a file got smaller
+	// (truncated or rotated), so readers of its tail know to start over. The
+	// old and new size can be read from the event's OldInfo and FileInfo.
+	DetectTruncation bool
+
+	// When non-zero, an observer that doesn't accept a batch within
+	// DeliveryTimeout is skipped for that batch (and the drop counted)
+	// instead of blocking the watcher. Zero means wait forever.
+	DeliveryTimeout time.Duration
+	dropped         uint64 // Batches dropped due to DeliveryTimeout, accessed atomically
+
+	// When non-zero, a scan without changes still delivers an empty batch if
+	// nothing was delivered for HeartbeatEvery, so observers can tell an idle
+	// watcher from a dead one. Heartbeats need scans, so they are only sent by
+	// the polling backend.
+	HeartbeatEvery time.Duration
+	lastSent       time.Time // When the last batch was delivered
+
+	// The number of events kept around for Drain(). Zero disables it.
+	DrainCapacity int
+	pull          eventRing
+
+	// The number of batches kept around for History(). Zero disables it.
+	HistorySize int
+	history     batchRing
+
+	// When non-zero, events are held back until their path has been quiet
+	// for Debounce, collapsing everything that happened to it meanwhile into
+	// a single event carrying the latest FileInfo.
+	Debounce time.Duration
+	pending  map[string]pendingEvent // Debounced events, by path
+
+	// When non-zero, the events of successive scans are collected and
+	// delivered as a single batch once BatchWindow has passed since the first
+	// of them, or as soon as MaxBatchSize events (if non-zero) are waiting.
+	// Events on the same path are collapsed, as with Debounce.
+	BatchWindow  time.Duration
+	MaxBatchSize int
+	held         map[string]Event // Events collected for BatchWindow, by path
+	heldSince    time.Time        // When the first of the held events came in
+
+	// When RateLimit and RatePeriod are non-zero, at most RateLimit batches
+	// with events are delivered per RatePeriod. The events of any more
+	// scans are kept back, collapsed as with Debounce, and delivered in the
+	// next batch that is allowed.
+	RateLimit  int
+	RatePeriod time.Duration
+	limited    map[string]Event // Events kept back by RateLimit, by path
+	sentAt     []time.Time      // When the batches of the current RatePeriod went out
+
+	extensions []string         // Set by WithExtensions, normalized
+	regexps    []*regexp.Regexp // Set by WithRegexp
+	watchPaths map[string]bool  // Set by WatchPaths, nil to watch everything
+
+	// Honor the .gitignore in the watched directory, as if its patterns were
+	// passed to Ignore (before any that were). It is read when the watcher
+	// starts.
+	Gitignore bool
+	ignores   []ignoreRule            // Set by Ignore
+	rules     map[string][]ignoreRule // The rules for each root, nil if there are none
+
+	errs    chan error  // Non-fatal scan errors, see Errors()
+	onError func(error) // Replaces errs when set, see SetErrorHandler()
+	errMu   sync.Mutex  // Guards onError, and serializes calls to it
+
+	// Where the watcher logs what it can't report otherwise: unused options,
+	// falling back to polling, dropped errors and batches, and (at debug
+	// level) its scans. Nil means no logging.
+	Logger *slog.Logger
+
+	stats statsCounters // See Stats()
 }
 
-//
 // Usage:
 //
 // import DW "util/directorywatcher"
 //
-// func main() {
-// 	dw := DW.New(".")
-//	c := dw.AddNewObserver()
-// 	dw.Start()
-//	for {
-//		select {
-//		case args := <-c:
-//			fmt.Printf("%d files changed at %s!\n", len(args.Events), args.At)
+//	func main() {
+//		dw := DW.New(".")
+//		c := dw.AddNewObserver()
+//		dw.Start()
+//		for {
+//			select {
+//			case args := <-c:
+//				fmt.Printf("%d files changed at %s!\n", len(args.Events), args.At)
+//			}
 //		}
-// 	}
-// }
+//	}
 //
-func New(path string) (*directoryWatcher, error) {
+// Options, such as WithExtensions, are applied in order after the defaults
+// are set up.
+func New(path string, opts ...Option) (*directoryWatcher, error) {
 	if stat, err := os.Stat(path); err != nil {
 		return nil, err
 	} else if !stat.IsDir() {
 		return nil, fmt.Errorf("Provided path is not a directory: %s", path)
 	}
 
-	return &directoryWatcher{
-		Interval:  2000,
-		Pattern:   "*",
-		observers: []Observer{},
-		path:      path,
-		scan:      globScanner, // Default is non-recursive
-		files:     make(map[string]os.FileInfo),
-	}, nil
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dw := &directoryWatcher{
+		Interval:   2000,
+		Pattern:    "*",
+		observers:  []*subscriber{},
+		path:       path,
+		absPath:    absPath,
+		roots:      []rootDir{{path, absPath}},
+		missing:    make(map[string]bool),
+		ScanBuffer: defaultScanBuffer,
+		files:      make(map[string]os.FileInfo),
+		clock:      realClock{},
+		pending:    make(map[string]pendingEvent),
+		errs:       make(chan error, errorBuffer),
+		ready:      make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+		intervals:  make(chan uint64, 1),
+	}
+	for _, opt := range opts {
+		if err := opt(dw); err != nil {
+			return nil, err
+		}
+	}
+	dw.selectScanner()
+	return dw, nil
+}
+
+// Watches the single file at path, which need not exist yet, reporting it as
+// Added, Changed or Deleted (and Added again when it's recreated). This is the
+// same as watching its directory with WatchPaths for just that file.
+func NewFile(path string, opts ...Option) (*directoryWatcher, error) {
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		return nil, fmt.Errorf("Provided path is a directory: %s", path)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	opts = append([]Option{WatchPaths(filepath.Base(path))}, opts...)
+	return New(filepath.Dir(path), opts...)
 }
 
 // Takesa map of options, using reflection to set the values that apply.
@@ -83,16 +331,16 @@ func NewOpts(path string, opts map[string]interface{}) (*directoryWatcher, error
 		if v, ok := opts[dwTyp.Field(i).Name]; ok {
 			field := dwValue.Field(i)
 			val := reflect.ValueOf(v)
-			if field.Kind() == val.Kind() {
-				field.Set(val)
+			if field.Kind() == val.Kind() && val.Type().ConvertibleTo(field.Type()) {
+				field.Set(val.Convert(field.Type()))
 				delete(opts, dwTyp.Field(i).Name)
 			}
 		}
 	}
 
-	// Warn about unused keys
+	// Warn about unused keys (a Logger among the options is already set)
 	for k, v := range opts {
-		fmt.Printf("Unused option: %s (%v)\n", k, v)
+		dw.log().Warn("Unused option", "name", k, "value", v)
 	}
 
 	return dw, nil
@@ -101,34 +349,345 @@ func NewOpts(path string, opts map[string]interface{}) (*directoryWatcher, error
 // The watcher runs in a goroutine, sending notifications back over to the
 // attached observers (channels). Notifications are only sent if any files have
 // actually changed.
+//
+// While no observers are attached (and DrainCapacity and HistorySize are
+// zero), the polling backend skips its scans. Nothing is lost by that: the
+// first scan after an observer is added reports everything that changed in the
+// meantime.
+//
+// Starting again after Stop() picks up from the files tracked before, so the
+// first scan reports what changed while the watcher was stopped. The scanner
+// is chosen anew, so changes to the fields take effect. Call Reset() first (or
+// use Restart) to start from scratch instead.
 func (dw *directoryWatcher) Start() {
-	if dw.ticker != nil {
+	dw.StartContext(context.Background())
+}
+
+// Like Start, but the watcher also stops when ctx is done, as if Stop() was
+// called. If the goroutine of a previous Start() is still delivering its last
+// events, this waits for it first.
+func (dw *directoryWatcher) StartContext(ctx context.Context) {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	if dw.running() {
 		return
 	}
-	if dw.Recursive { // Switch to recursive scanner, if requested
-		dw.scan = recScanner
+	dw.stop() // In case the watcher stopped by itself
+	dw.wait()
+	ctx, dw.cancel = context.WithCancel(ctx)
+	dw.selectScanner()
+	dw.done = make(chan struct{})
+	select {
+	case <-dw.ready:
+		dw.ready = make(chan struct{}) // Started before, wait for the new baseline
+	default:
 	}
+	if dw.Backend == Native {
+		n, err := dw.startNative(ctx)
+		if err == nil {
+			dw.native = n
+			close(dw.ready)
+			return
+		}
+		dw.log().Warn("Native backend unavailable, polling instead", "path", dw.path, "err", err)
+	}
+	dw.startPolling(ctx)
+}
+
+// Closed once the first scan after Start() is done, so changes made from then
+// on are reported relative to it. Unlike Running(), which is true as soon as
+// Start() returns, this tells when the watcher is actually live. Starting again
+// after a Stop() makes a new channel.
+func (dw *directoryWatcher) Ready() <-chan struct{} {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	return dw.ready
+}
 
+// Installs the scanner the fields ask for (a recursive one, if requested),
+// unless one was given with WithScanner. This happens in New, and again in
+// Start, in case the fields were changed in between.
+func (dw *directoryWatcher) selectScanner() {
+	dw.compileIgnores()
+	if dw.custom {
+		return
+	}
+	switch {
+	case dw.watchPaths != nil:
+		dw.scan = pathsScanner(dw.watchPaths, dw.ScanBuffer, dw.FollowSymlinks, dw.skipped, dw.reportError)
+	case dw.Recursive && dw.FollowSymlinks:
+		dw.scan = followScanner(dw.ScanBuffer, dw.skipped, dw.reportError)
+	case dw.Recursive && dw.IncrementalScan:
+		dw.scan = incrementalScanner(dw.ScanBuffer, dw.skipped, dw.reportError)
+	case dw.Recursive && dw.ParallelScan:
+		dw.scan = parallelScanner(dw.ScanWorkers, dw.ScanBuffer, dw.skipped, dw.reportError)
+	case dw.Recursive:
+		dw.scan = recScanner(dw.ScanBuffer, dw.skipped, dw.reportError)
+	default:
+		dw.scan = globScanner(dw.ScanBuffer, dw.FollowSymlinks, dw.skipped, dw.reportError)
+	}
+}
+
+// The ticker is created before the goroutine starts, so Stop() can be called
+// right after Start().
+func (dw *directoryWatcher) startPolling(ctx context.Context) {
+	select {
+	case dw.Interval = <-dw.intervals: // Set as the previous goroutine exited
+	default:
+	}
+	interval := dw.firstInterval()
+	t := dw.clock.NewTicker(interval)
+	done, ready := dw.done, dw.ready
+	dw.ticker = t
 	go func() {
-		now := time.Now()
-		if fst := dw.scan2(); !dw.Preload {
-			dw.notify(EventsAt{now, fst})
-		}
-		dw.ticker = time.NewTicker(time.Duration(dw.Interval) * time.Millisecond)
-		for now = range dw.ticker.C {
-			dw.notify(EventsAt{now, dw.scan2()})
+		defer close(done)
+		defer t.Stop()
+		now := dw.clock.Now()
+		baseline := dw.batch(now, dw.firstScan())
+		close(ready)
+		dw.notifyBaseline(baseline)
+		var flush <-chan time.Time
+		gone := false // Whether the watched directory was removed
+		for {
+			select {
+			case now = <-t.Chan():
+				if dw.idle() {
+					break // Scan once someone is listening, against the old snapshot
+				}
+				if gone && dw.rootGone() {
+					break // Still waiting for it to come back
+				}
+				changed := dw.scan2()
+				dw.notify(dw.batch(now, dw.outgoing(now, changed)))
+				if dw.AdaptiveInterval {
+					interval = dw.nextInterval(interval, len(changed))
+					t.Reset(interval)
+				}
+				if gone = dw.rootGone(); gone && !dw.WaitForRoot {
+					dw.flushPending()
+					return
+				}
+			case now = <-flush:
+				dw.notify(dw.flushBatch(now))
+			case <-dw.wake:
+				now = dw.clock.Now()
+				dw.notify(dw.flushBatch(now))
+			case dw.Interval = <-dw.intervals:
+				interval = dw.firstInterval()
+				t.Reset(interval)
+				now = dw.clock.Now()
+			case <-ctx.Done():
+				dw.flushPending()
+				return
+			}
+			flush = dw.flushTimer(now)
 		}
 	}()
 }
 
+// Whether every watched directory no longer exists. Each one that went
+// missing since the last call is reported on Errors() as ErrRootRemoved.
+func (dw *directoryWatcher) rootGone() bool {
+	gone := true
+	for _, root := range dw.roots {
+		_, err := os.Stat(root.path)
+		if !os.IsNotExist(err) {
+			delete(dw.missing, root.path)
+			gone = false
+			continue
+		}
+		if !dw.missing[root.path] {
+			dw.missing[root.path] = true
+			dw.reportError(&os.PathError{Op: "stat", Path: root.path, Err: ErrRootRemoved})
+		}
+	}
+	return gone
+}
+
+// The interval the ticker is started with.
+func (dw *directoryWatcher) firstInterval() time.Duration {
+	if dw.AdaptiveInterval {
+		min, _ := dw.intervalBounds()
+		return min
+	}
+	return time.Duration(dw.Interval) * time.Millisecond
+}
+
+// The bounds of the adaptive interval, with the defaults filled in.
+func (dw *directoryWatcher) intervalBounds() (min, max time.Duration) {
+	minMs, maxMs := dw.MinInterval, dw.MaxInterval
+	if minMs == 0 {
+		minMs = dw.Interval
+	}
+	if maxMs < minMs {
+		maxMs = minMs
+	}
+	return time.Duration(minMs) * time.Millisecond, time.Duration(maxMs) * time.Millisecond
+}
+
+// Computes the adaptive interval to use after a scan that found the given
+// number of changes: back off while idle, reset on activity.
+func (dw *directoryWatcher) nextInterval(cur time.Duration, changes int) time.Duration {
+	min, max := dw.intervalBounds()
+	if changes > 0 {
+		return min
+	}
+	if next := 2 * cur; next < max {
+		return next
+	}
+	return max
+}
+
+// Changes Interval, also while the watcher is running: its ticker is reset, so
+// the next scan is d from now. With AdaptiveInterval, the interval starts over
+// from MinInterval (or the new Interval, if that is zero). The native backend
+// doesn't poll, so it isn't affected.
+func (dw *directoryWatcher) SetInterval(d time.Duration) error {
+	if d < time.Millisecond {
+		return fmt.Errorf("Interval must be at least 1ms: %s", d)
+	}
+	ms := uint64(d / time.Millisecond)
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	if !dw.alive() {
+		dw.Interval = ms
+		return nil
+	}
+	for {
+		select {
+		case dw.intervals <- ms:
+			return nil
+		case <-dw.intervals: // Not picked up yet, replace it
+		}
+	}
+}
+
+// Stops the watcher, the same as cancelling the context given to
+// StartContext. Events still held back by Debounce are delivered by the
+// watcher's goroutine on its way out, so the last change before shutdown isn't
+// lost; Stop doesn't wait for that to happen (StopAndWait does).
 func (dw *directoryWatcher) Stop() {
-	dw.ticker.Stop()
-	dw.ticker = nil
+	dw.runMu.Lock()
+	dw.stop()
+	dw.runMu.Unlock()
+}
+
+// Like Stop, but waits for the watcher's goroutine to deliver its last events
+// and exit, so nothing is sent to the observers once it returns (follow with
+// UnsubscribeAll(true) to close them). Like Restart, it blocks for as long as
+// an observer doesn't accept those events.
+func (dw *directoryWatcher) StopAndWait() {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	dw.stop()
+	dw.wait()
+}
+
+func (dw *directoryWatcher) stop() {
+	if dw.native != nil {
+		dw.native.Close()
+		dw.native = nil
+	}
+	if dw.ticker != nil {
+		dw.ticker.Stop()
+		dw.ticker = nil
+	}
+	if dw.cancel != nil {
+		dw.cancel()
+		dw.cancel = nil
+	}
+}
+
+// Stops the watcher, forgets every tracked file and starts again, so the first
+// scan establishes a fresh baseline instead of being compared against the
+// snapshot from before the Stop(). Changes made while the watcher was stopped
+// are therefore not reported as such: with Preload the files present at
+// Restart() are silently taken as the new baseline, without it they are all
+// reported as Initial, just like after the first Start().
+//
+// Restart waits for the previous goroutine to deliver its pending events and
+// exit, so it blocks for as long as an observer doesn't accept them.
+func (dw *directoryWatcher) Restart() {
+	dw.runMu.Lock()
+	dw.stop()
+	dw.wait()
+	dw.reset()
+	dw.runMu.Unlock()
+	dw.Start()
+}
+
+// Forgets every tracked file, and whatever events were kept back (by Debounce,
+// BatchWindow, RateLimit or Pause), so the next Start() begins with a fresh
+// baseline, as if the watcher was new. The observers stay. The watcher must not
+// be running; if it was just stopped, Reset waits for it to deliver its last
+// events.
+func (dw *directoryWatcher) Reset() error {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	if dw.running() {
+		return errRunning
+	}
+	dw.wait()
+	dw.reset()
+	return nil
+}
+
+func (dw *directoryWatcher) reset() {
+	dw.files = make(map[string]os.FileInfo)
+	dw.pending = make(map[string]pendingEvent)
+	dw.held, dw.heldSince = nil, time.Time{}
+	dw.limited, dw.sentAt = nil, nil
+	dw.missed = nil
+	dw.missing = make(map[string]bool)
 }
 
-// We use the ticker to decide whether or not we're running.
+// Closed when the goroutine of the last Start() has exited, nil if the
+// watcher was never started.
+func (dw *directoryWatcher) exited() <-chan struct{} {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	return dw.done
+}
+
+// Waits for the goroutine of the last Start() to exit.
+func (dw *directoryWatcher) wait() {
+	if dw.done != nil {
+		<-dw.done
+	}
+}
+
+// Whether the goroutine of the last Start() is still there, even if it's on
+// its way out.
+func (dw *directoryWatcher) alive() bool {
+	if dw.done == nil {
+		return false
+	}
+	select {
+	case <-dw.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// We use the ticker (or the native backend) to decide whether or not we're
+// running, unless the watcher stopped by itself.
 func (dw *directoryWatcher) Running() bool {
-	return dw.ticker != nil
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	return dw.running()
+}
+
+func (dw *directoryWatcher) running() bool {
+	if dw.ticker == nil && dw.native == nil {
+		return false
+	}
+	select {
+	case <-dw.done:
+		return false
+	default:
+		return true
+	}
 }
 
 func NewObserver() Observer {
@@ -137,46 +696,295 @@ func NewObserver() Observer {
 
 func (dw *directoryWatcher) AddNewObserver() Observer {
 	o := make(Observer)
-	dw.observers = append(dw.observers, o)
+	dw.AddObserver(o)
 	return o
 }
 
+// Adds an observer with priority 0.
 func (dw *directoryWatcher) AddObserver(obs Observer) {
-	dw.observers = append(dw.observers, obs)
+	dw.AddObserverPriority(obs, 0)
+}
+
+// Adds an observer that is delivered to before every observer with a lower
+// priority. Observers with the same priority are delivered to in the order they
+// were added.
+func (dw *directoryWatcher) AddObserverPriority(obs Observer, priority int) {
+	dw.subscribe(newSubscriber(obs, priority))
+}
+
+// Adds an observer that only receives events of the given types. Batches are
+// filtered before they're sent, and not sent at all if none of their events
+// match.
+func (dw *directoryWatcher) AddObserverFiltered(obs Observer, types ...eventType) {
+	sub := newSubscriber(obs, 0)
+	want := make(map[eventType]bool)
+	for _, typ := range types {
+		want[typ] = true
+	}
+	sub.accept = func(ev Event) bool { return want[ev.Type] }
+	dw.subscribe(sub)
+}
+
+// Inserts sub after every observer with the same or a higher priority.
+func (dw *directoryWatcher) subscribe(sub *subscriber) {
+	dw.obsMu.Lock()
+	defer dw.obsMu.Unlock()
+	i := sort.Search(len(dw.observers), func(i int) bool {
+		return dw.observers[i].priority < sub.priority
+	})
+	observers := make([]*subscriber, 0, len(dw.observers)+1)
+	observers = append(observers, dw.observers[:i]...)
+	observers = append(observers, sub)
+	dw.observers = append(observers, dw.observers[i:]...)
+}
+
+// The number of observers attached.
+func (dw *directoryWatcher) ObserverCount() int {
+	dw.obsMu.RLock()
+	defer dw.obsMu.RUnlock()
+	return len(dw.observers)
+}
+
+// Whether there's nobody to tell about changes: no observers, and nothing
+// kept for Drain() or History().
+func (dw *directoryWatcher) idle() bool {
+	return dw.ObserverCount() == 0 && dw.DrainCapacity == 0 && dw.HistorySize == 0
+}
+
+// Wraps up the events of a scan, numbering it and recording how many files
+// are tracked after it. Every scan makes exactly one batch.
+//
+// The events are sorted by path (and type), as the order they're found in
+// depends on map iteration.
+func (dw *directoryWatcher) batch(at time.Time, events []Event) EventsAt {
+	dw.scanSeq++
+	return dw.newBatch(at, events)
+}
+
+// A batch of debounced or held events that became due between scans.
+func (dw *directoryWatcher) flushBatch(at time.Time) EventsAt {
+	return dw.newBatch(at, dw.outgoing(at, nil))
+}
+
+func (dw *directoryWatcher) newBatch(at time.Time, events []Event) EventsAt {
+	for i := range events {
+		events[i].Path = dw.reportedPath(events[i].Path)
+		if events[i].OldPath != "" {
+			events[i].OldPath = dw.reportedPath(events[i].OldPath)
+		}
+	}
+	sortEvents(events)
+	return EventsAt{
+		At:         at,
+		Events:     events,
+		ScanSeq:    dw.scanSeq,
+		TotalFiles: len(dw.files),
+		Source:     dw.path,
+	}
 }
 
-// Only sends notification if the number of events is greater than zero
+// The scan establishing the baseline. Against an empty snapshot, what it finds
+// was there all along, so it's reported as Initial rather than Added. After a
+// Stop() (or LoadState) it's compared against the old snapshot as usual.
+func (dw *directoryWatcher) firstScan() []Event {
+	fresh := len(dw.files) == 0
+	events := dw.scan2()
+	if fresh {
+		for i := range events {
+			if events[i].Type == Added {
+				events[i].Type = Initial
+			}
+		}
+	}
+	return events
+}
+
+// By path, then type
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Path != events[j].Path {
+			return events[i].Path < events[j].Path
+		}
+		return events[i].Type < events[j].Type
+	})
+}
+
+// Delivers the batch of the first scan: as is without Preload, as a possibly
+// empty snapshot with PreloadSnapshot, and not at all otherwise.
+func (dw *directoryWatcher) notifyBaseline(evAt EventsAt) {
+	evAt.Baseline = true
+	switch {
+	case !dw.Preload:
+		dw.notify(evAt)
+	case dw.PreloadSnapshot && !dw.suspend(evAt):
+		dw.send(evAt)
+	}
+}
+
+// Only sends notification if the number of events is greater than zero, or
+// if it's time for a heartbeat.
 func (dw *directoryWatcher) notify(evAt EventsAt) {
-	if len(evAt.Events) == 0 {
+	if dw.suspend(evAt) {
 		return
 	}
-	for _, ch := range dw.observers {
-		ch <- evAt
+	evAt = dw.catchUp(evAt)
+	if len(evAt.Events) > 0 && dw.DrainCapacity > 0 {
+		dw.pull.push(dw.DrainCapacity, evAt.Events)
+	}
+	if len(evAt.Events) > 0 && dw.HistorySize > 0 {
+		dw.history.push(dw.HistorySize, evAt)
+	}
+	if len(evAt.Events) == 0 && !dw.heartbeatDue(evAt.At) {
+		return
 	}
+	dw.send(evAt)
+}
+
+func (dw *directoryWatcher) heartbeatDue(now time.Time) bool {
+	return dw.HeartbeatEvery > 0 && now.Sub(dw.lastSent) >= dw.HeartbeatEvery
+}
+
+func (dw *directoryWatcher) send(evAt EventsAt) {
+	dw.lastSent = evAt.At
+	dw.stats.sent(evAt.Events)
+	dw.obsMu.RLock()
+	observers := dw.observers
+	dw.obsMu.RUnlock()
+	for _, sub := range observers {
+		evAt, ok := sub.filter(evAt)
+		if ok && !sub.deliver(evAt, dw.DeliveryTimeout) {
+			atomic.AddUint64(&dw.dropped, 1)
+			dw.log().Warn("Dropped a batch", "path", dw.path, "scanSeq", evAt.ScanSeq, "events", len(evAt.Events))
+		}
+	}
+}
+
+// The number of batches that observers didn't accept within DeliveryTimeout.
+func (dw *directoryWatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&dw.dropped)
 }
 
 // The actual walking function: Scans and returns a list of events on all the
 // files that somehow changed (added, changed or deleted).
 func (dw *directoryWatcher) scan2() (changed []Event) {
+	start := time.Now()
+	defer func() {
+		took := time.Since(start)
+		dw.stats.scanned(took, len(dw.files))
+		dw.log().Debug("Scanned", "path", dw.path, "events", len(changed), "files", len(dw.files), "took", took)
+	}()
 	touched := make(map[string]bool)
-	for pair := range dw.scan(dw.path) {
-		path, info := pair()
-		if info.IsDir() || !matches(dw.Pattern, info.Name()) {
-			continue
-		}
-		if ev, yes := dw.hasChange(path, info); yes {
-			dw.files[path] = info
-			changed = append(changed, ev)
+	roots := dw.roots
+	if dw.watchPaths != nil {
+		roots = roots[:1] // The paths are the same whichever root is scanned
+	}
+	for _, root := range roots {
+		for pair := range dw.scan(root.path) {
+			path, info := pair()
+			if info.IsDir() && !touched[path] {
+				if ev, yes := dw.dirAdded(path, info); yes {
+					changed = append(changed, ev)
+				}
+				touched[path] = dw.ReportDirectories
+			}
+			if info.IsDir() || !dw.included(path) || touched[path] {
+				continue
+			}
+			if ev, yes := dw.hasChange(path, info); yes {
+				dw.files[path] = info
+				changed = append(changed, ev)
+			}
+			touched[path] = true
 		}
-		touched[path] = true
 	}
 	for path, info := range dw.files {
 		if !touched[path] {
-			changed = append(changed, Event{Deleted, path, info})
+			changed = append(changed, deletedEvent(path, info))
 			delete(dw.files, path)
 		}
 	}
-	return
+	return dw.pairRenames(changed)
+}
+
+// Whether the file at path is watched, by matching any of the patterns.
+//
+// A pattern without a slash is matched against the name of the file. One with
+// a slash is matched against its path below the watched directory, with "**"
+// matching any number of directories, e.g. "src/**/*.go"; recursive scans
+// then don't descend into directories the patterns can't match anything in.
+func (dw *directoryWatcher) included(path string) bool {
+	name := filepath.Base(path)
+	if !dw.hasExtension(name) || !dw.matchesRegexp(path) {
+		return false
+	}
+	var rel string
+	for _, pattern := range dw.patterns() {
+		if !strings.Contains(pattern, "/") {
+			if matches(pattern, name) {
+				return true
+			}
+			continue
+		}
+		if rel == "" {
+			var err error
+			if rel, err = filepath.Rel(dw.rootOf(path).path, path); err != nil {
+				return false
+			}
+		}
+		if matchPath(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Patterns, or else Pattern.
+func (dw *directoryWatcher) patterns() []string {
+	if len(dw.Patterns) > 0 {
+		return dw.Patterns
+	}
+	return []string{dw.Pattern}
+}
+
+// Whether every pattern has a slash, so that only some directories can hold
+// files that match.
+func (dw *directoryWatcher) pathPatterns() bool {
+	for _, pattern := range dw.patterns() {
+		if !strings.Contains(pattern, "/") {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the scanners should leave out path: it's ignored, below MaxDepth,
+// or a directory the patterns can't match anything in.
+func (dw *directoryWatcher) skipped(path string, isDir bool) bool {
+	if dw.ignored(path, isDir) {
+		return true
+	}
+	pathPatterns := isDir && dw.pathPatterns()
+	if dw.MaxDepth <= 0 && !pathPatterns {
+		return false
+	}
+	rel, err := filepath.Rel(dw.rootOf(path).path, path)
+	if err != nil || rel == "." {
+		return false
+	}
+	if depth := strings.Count(rel, string(filepath.Separator)) + 1; dw.MaxDepth > 0 {
+		if depth > dw.MaxDepth || (isDir && depth >= dw.MaxDepth) {
+			return true
+		}
+	}
+	if !pathPatterns {
+		return false
+	}
+	for _, pattern := range dw.patterns() {
+		if matchPrefix(pattern, rel) {
+			return false
+		}
+	}
+	return true
 }
 
 func matches(pattern, name string) bool {
@@ -190,39 +998,253 @@ func matches(pattern, name string) bool {
 //
 // The advantage of these are that we can construct them anonymously, pass them
 // on a channel and easily pick out its values.
-type strFileInfo func() (string, os.FileInfo)
+type StrFileInfo func() (string, os.FileInfo)
 
-// Scanning function
-type scanFn func(path string) <-chan strFileInfo
+// A scanning function, as given to WithScanner. It is called with a watched
+// directory at every scan and sends the path and FileInfo of each file found
+// below it (directories too, if ReportDirectories is to work), then closes the
+// channel. Paths should start with the directory as given, like those of
+// filepath.Walk. The watcher still filters files by Pattern and extension,
+// but leaving out ignored paths (and respecting MaxDepth) is up to the
+// scanner. For instance, to only look at the files listed in a manifest:
+//
+//	func(root string) <-chan StrFileInfo {
+//		c := make(chan StrFileInfo)
+//		go func() {
+//			defer close(c)
+//			for _, rel := range manifest {
+//				path := filepath.Join(root, rel)
+//				if info, err := os.Stat(path); err == nil {
+//					c <- func() (string, os.FileInfo) { return path, info }
+//				}
+//			}
+//		}()
+//		return c
+//	}
+type ScanFunc func(path string) <-chan StrFileInfo
 
-func wrapFn(p string, fi os.FileInfo) strFileInfo {
+func wrapFn(p string, fi os.FileInfo) StrFileInfo {
 	return func() (string, os.FileInfo) { return p, fi }
 }
 
-func recScanner(path string) <-chan strFileInfo {
-	c := make(chan strFileInfo)
-	go func() {
-		filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-			c <- wrapFn(path, info)
-			return err
-		})
-		close(c)
-	}()
-	return c
+// The built-in scanners are made for a channel buffer size, so the scanning
+// goroutine doesn't have to hand over every single file to scan2, a function
+// telling which files and directories to leave out (see Ignore), and a
+// function to report errors to. An error only means that some file or
+// directory is skipped; the scan goes on with the rest.
+
+type errorFn func(err error)
+
+type skipFn func(path string, isDir bool) bool
+
+// Stats a file found by a scan: by what it points to if it's a symlink and
+// follow is set, otherwise by the link itself (see linkInfo).
+func statEntry(path string, follow bool) (os.FileInfo, error) {
+	if follow {
+		return os.Stat(path)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	return linkInfo(path, info), nil
 }
 
-func globScanner(path string) <-chan strFileInfo {
-	c := make(chan strFileInfo)
-	go func() {
-		all, _ := filepath.Glob(filepath.Join(path, "*"))
-		for _, p := range all {
-			if info, err := os.Stat(p); err == nil {
-				c <- wrapFn(p, info)
+// The info of a link to a directory is that of the directory, so it's left
+// out like one; anything else, unresolvable links included, is info itself.
+func linkInfo(path string, info os.FileInfo) os.FileInfo {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return info
+	}
+	if target, err := os.Stat(path); err == nil && target.IsDir() {
+		return target
+	}
+	return info
+}
+
+func recScanner(buffer int, skip skipFn, report errorFn) ScanFunc {
+	return func(path string) <-chan StrFileInfo {
+		c := make(chan StrFileInfo, buffer)
+		go func() {
+			filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					report(err)
+					return nil
+				}
+				if skip(path, info.IsDir()) {
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				c <- wrapFn(path, linkInfo(path, info))
+				return nil
+			})
+			close(c)
+		}()
+		return c
+	}
+}
+
+// Like recScanner, but symlinks are followed. Every directory is entered at
+// most once, keyed on its resolved path, so a link cycle can't make the walk go
+// on forever.
+func followScanner(buffer int, skip skipFn, report errorFn) ScanFunc {
+	return func(path string) <-chan StrFileInfo {
+		c := make(chan StrFileInfo, buffer)
+		go func() {
+			walkFollow(path, make(map[string]bool), c, skip, report)
+			close(c)
+		}()
+		return c
+	}
+}
+
+func walkFollow(path string, visited map[string]bool, c chan<- StrFileInfo, skip skipFn, report errorFn) {
+	info, err := os.Stat(path)
+	if err != nil {
+		report(err)
+		return
+	}
+	if skip(path, info.IsDir()) {
+		return
+	}
+	c <- wrapFn(path, info)
+	if !info.IsDir() {
+		return
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		report(err)
+		return
+	}
+	if visited[resolved] {
+		return
+	}
+	visited[resolved] = true
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		report(err)
+		return
+	}
+	for _, entry := range entries {
+		walkFollow(filepath.Join(path, entry.Name()), visited, c, skip, report)
+	}
+}
+
+// Yields the given paths, if they exist, instead of looking for files.
+func pathsScanner(paths map[string]bool, buffer int, follow bool, skip skipFn, report errorFn) ScanFunc {
+	return func(string) <-chan StrFileInfo {
+		c := make(chan StrFileInfo, buffer)
+		go func() {
+			for p := range paths {
+				if info, err := statEntry(p, follow); err == nil {
+					if skip(p, info.IsDir()) {
+						continue
+					}
+					c <- wrapFn(p, info)
+				} else if !os.IsNotExist(err) {
+					report(err)
+				}
 			}
-		}
-		close(c)
-	}()
-	return c
+			close(c)
+		}()
+		return c
+	}
+}
+
+func globScanner(buffer int, follow bool, skip skipFn, report errorFn) ScanFunc {
+	return func(path string) <-chan StrFileInfo {
+		c := make(chan StrFileInfo, buffer)
+		go func() {
+			defer close(c)
+			// Unlike filepath.Glob, ReadDir tells when the directory can't
+			// be read. A removed root is reported by the watcher itself.
+			entries, err := os.ReadDir(path)
+			if err != nil && !os.IsNotExist(err) {
+				report(err)
+			}
+			for _, entry := range entries {
+				p := filepath.Join(path, entry.Name())
+				if info, err := statEntry(p, follow); err != nil {
+					report(err)
+				} else if !skip(p, info.IsDir()) {
+					c <- wrapFn(p, info)
+				}
+			}
+		}()
+		return c
+	}
+}
+
+// Non-fatal errors from scanning, such as an unreadable directory or a file
+// that couldn't be stat'ed, are reported here as *ScanError, unless an error
+// handler is set. The scan skips whatever failed and carries on. So are
+// commands that couldn't be started by ExecCommand and batches AddWebhook
+// couldn't deliver. Errors that don't fit in the channel's buffer are dropped,
+// so a watcher whose errors aren't read keeps working.
+func (dw *directoryWatcher) Errors() <-chan error {
+	return dw.errs
+}
+
+// Have scan errors passed to fn instead of being sent on Errors(). The
+// handler is called from the scanning goroutine, one error at a time, and
+// should return quickly. A nil fn restores the channel.
+func (dw *directoryWatcher) SetErrorHandler(fn func(error)) {
+	dw.errMu.Lock()
+	dw.onError = fn
+	dw.errMu.Unlock()
+}
+
+func (dw *directoryWatcher) reportError(err error) {
+	serr := &ScanError{Err: err}
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		serr = &ScanError{pe.Path, pe.Op, pe.Err}
+	}
+
+	dw.errMu.Lock()
+	defer dw.errMu.Unlock()
+	if dw.onError != nil {
+		dw.onError(serr)
+		return
+	}
+	select {
+	case dw.errs <- serr:
+	default:
+		dw.log().Warn("Dropped an error, Errors() is full", "err", serr)
+	}
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
+
+func (dw *directoryWatcher) log() *slog.Logger {
+	if dw.Logger == nil {
+		return discardLogger
+	}
+	return dw.Logger
+}
+
+// A file that is gone can't be stat'ed, so a Deleted event carries the
+// FileInfo from the last scan that saw the file, both embedded and as
+// OldInfo.
+func deletedEvent(path string, lastInfo os.FileInfo) Event {
+	return Event{Deleted, path, lastInfo, lastInfo, ""}
+}
+
+// With ReportDirectories, a directory (other than a watched one) that isn't
+// tracked yet is, and is reported as Added. Directories are never reported as
+// Changed, as their modtime changes whenever a file in them is added or
+// removed.
+func (dw *directoryWatcher) dirAdded(path string, info os.FileInfo) (Event, bool) {
+	if !dw.ReportDirectories || dw.rootOf(path).path == path {
+		return Event{}, false
+	}
+	if _, ok := dw.files[path]; ok {
+		return Event{}, false
+	}
+	dw.files[path] = info
+	return Event{Added, path, info, nil, ""}, true
 }
 
 // This tells us if a given file has been changed or added.
@@ -230,7 +1252,58 @@ func globScanner(path string) <-chan strFileInfo {
 // Uses the comma-ok style to indicate whether or not a given file actually changed.
 func (dw *directoryWatcher) hasChange(path string, info os.FileInfo) (Event, bool) {
 	if oldInfo, ok := dw.files[path]; ok {
-		return Event{Changed, path, info}, info.ModTime().After(oldInfo.ModTime())
+		var changed bool
+		if dw.Comparator != nil {
+			changed = dw.Comparator(oldInfo, info, path)
+		} else {
+			changed = dw.differs(oldInfo, info)
+		}
+		if dw.DetectTruncation && info.Size() < oldInfo.Size() {
+			return Event{Truncated, path, info, oldInfo, ""}, true
+		}
+		if dw.DetectAttrChanges && !changed && attrsChanged(info, oldInfo) {
+			return Event{AttrChanged, path, info, oldInfo, ""}, true
+		}
+		return Event{Changed, path, info, oldInfo, ""}, changed
 	}
-	return Event{Added, path, info}, true
+	return Event{Added, path, info, nil, ""}, true
+}
+
+// The comparison used without a Comparator.
+func (dw *directoryWatcher) differs(oldInfo, info os.FileInfo) bool {
+	switch {
+	case info.ModTime().After(oldInfo.ModTime()):
+		return true
+	case dw.DetectModtimeRegression && !info.ModTime().Equal(oldInfo.ModTime()):
+		return true
+	case dw.DetectSizeChanges && info.Size() != oldInfo.Size():
+		return true
+	case dw.DetectModeChanges && !dw.DetectAttrChanges && permBits(info) != permBits(oldInfo):
+		return true
+	}
+	return replaced(info, oldInfo)
+}
+
+// Whether the file is a different one than before (atomically replaced, say),
+// even if its size and modtime are the same. This needs inodes, so it's never
+// true on platforms without them.
+func replaced(info, oldInfo os.FileInfo) bool {
+	id, ok := fileID(info)
+	oldID, oldOk := fileID(oldInfo)
+	return ok && oldOk && id != oldID
+}
+
+// Whether a file's permission bits, owner or group differ from before.
+func attrsChanged(info, oldInfo os.FileInfo) bool {
+	if permBits(info) != permBits(oldInfo) {
+		return true
+	}
+	uid, gid, ok := fileOwner(info)
+	oldUID, oldGID, oldOk := fileOwner(oldInfo)
+	return ok && oldOk && (uid != oldUID || gid != oldGID)
+}
+
+// The permission bits of a file, including setuid, setgid and sticky.
+func permBits(info os.FileInfo) os.FileMode {
+	return info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
 }
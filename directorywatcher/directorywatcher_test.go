@@ -0,0 +1,866 @@
+package directorywatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Writes a file with the given content and sets its modification time, so
+// tests don't depend on the file system's timestamp granularity.
+func writeFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestWatcher(t *testing.T, dir string) *directoryWatcher {
+	t.Helper()
+	dw, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dw
+}
+
+// Picks out the only event of a scan, failing if there is not exactly one.
+func onlyEvent(t *testing.T, events []Event) Event {
+	t.Helper()
+	if len(events) != 1 {
+		t.Fatalf("expected exactly one event, got %v", events)
+	}
+	return events[0]
+}
+
+func TestChangedEventOldInfo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "short", then)
+
+	dw := newTestWatcher(t, dir)
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Added || ev.OldInfo != nil {
+		t.Errorf("expected Added event without OldInfo, got %v (OldInfo %v)", ev, ev.OldInfo)
+	}
+
+	writeFile(t, path, "a bit longer", then.Add(time.Minute))
+	ev := onlyEvent(t, dw.scan2())
+	if ev.Type != Changed {
+		t.Fatalf("expected Changed event, got %v", ev)
+	}
+	if ev.OldInfo == nil {
+		t.Fatal("Changed event has no OldInfo")
+	}
+	if ev.OldInfo.Size() != 5 || ev.Size() != 12 {
+		t.Errorf("expected size 5 -> 12, got %d -> %d", ev.OldInfo.Size(), ev.Size())
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	ev = onlyEvent(t, dw.scan2())
+	if ev.Type != Deleted || ev.OldInfo == nil || ev.OldInfo.Size() != 12 {
+		t.Errorf("expected Deleted event carrying the last-known info, got %v (OldInfo %v)", ev, ev.OldInfo)
+	}
+}
+
+func TestDetectModeChanges(t *testing.T) {
+	for _, detect := range []bool{false, true} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "a.sh")
+		writeFile(t, path, "#!/bin/sh", time.Now().Add(-time.Hour))
+		if err := os.Chmod(path, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		dw := newTestWatcher(t, dir)
+		dw.DetectModeChanges = detect
+		dw.scan2()
+
+		if err := os.Chmod(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		changed := dw.scan2()
+		if !detect {
+			if len(changed) != 0 {
+				t.Errorf("mode change reported without DetectModeChanges: %v", changed)
+			}
+			continue
+		}
+		ev := onlyEvent(t, changed)
+		if ev.Type != Changed {
+			t.Fatalf("expected Changed event, got %v", ev)
+		}
+		if ev.OldInfo.Mode().Perm() != 0644 || ev.Mode().Perm() != 0755 {
+			t.Errorf("expected mode 0644 -> 0755, got %o -> %o", ev.OldInfo.Mode().Perm(), ev.Mode().Perm())
+		}
+		if again := dw.scan2(); len(again) != 0 {
+			t.Errorf("mode change reported twice: %v", again)
+		}
+	}
+}
+
+// Waits for the next batch on c.
+func receive(t *testing.T, c Observer) EventsAt {
+	t.Helper()
+	select {
+	case evAt := <-c:
+		return evAt
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for events")
+	}
+	return EventsAt{}
+}
+
+func TestDeliveryTimeout(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.Interval = 10
+	dw.DeliveryTimeout = 20 * time.Millisecond
+	dw.AddNewObserver() // Never read from
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	<-dw.Ready() // Files written from here on are Added, not Initial
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, name, time.Now())
+		if ev := onlyEvent(t, receive(t, c).Events); ev.Type != Added || ev.Path != path {
+			t.Errorf("expected Added %s, got %v", path, ev)
+		}
+	}
+	if dropped := dw.Dropped(); dropped != 2 {
+		t.Errorf("expected 2 dropped batches, got %d", dropped)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	for _, preload := range []bool{false, true} {
+		dir := t.TempDir()
+		then := time.Now().Add(-time.Hour)
+		writeFile(t, filepath.Join(dir, "a.txt"), "a", then)
+		writeFile(t, filepath.Join(dir, "b.txt"), "b", then)
+
+		dw := newTestWatcher(t, dir)
+		fc := newFakeClock()
+		dw.clock = fc
+		c := dw.AddNewObserver()
+		dw.Start()
+		fc.ticker(t)
+		if added := receive(t, c).Events; len(added) != 2 {
+			t.Fatalf("expected two Added events, got %v", added)
+		}
+		dw.Stop()
+
+		// Change the directory while the watcher isn't looking
+		if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+			t.Fatal(err)
+		}
+		cPath := filepath.Join(dir, "c.txt")
+		writeFile(t, cPath, "c", then)
+
+		dw.Preload = preload
+		dw.Restart()
+		ft := fc.ticker(t)
+		if preload {
+			ft.c <- fc.now // Only accepted after the first scan
+		} else {
+			added := receive(t, c).Events
+			if len(added) != 2 {
+				t.Fatalf("expected the new baseline as two Initial events, got %v", added)
+			}
+			for _, ev := range added {
+				if ev.Type != Initial || ev.Name() == "a.txt" {
+					t.Errorf("unexpected event in new baseline: %v", ev)
+				}
+			}
+		}
+
+		writeFile(t, cPath, "cc", then.Add(time.Minute))
+		if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Changed || ev.Path != cPath {
+			t.Errorf("expected c.txt to be part of the baseline, got %v", ev)
+		}
+	}
+}
+
+func TestScanSeqAndTotalFiles(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", then)
+	writeFile(t, filepath.Join(dir, "b.txt"), "b", then)
+
+	dw := newTestWatcher(t, dir)
+	scan := func() EventsAt { return dw.batch(time.Now(), dw.scan2()) }
+	if evAt := scan(); evAt.ScanSeq != 1 || evAt.TotalFiles != 2 {
+		t.Errorf("first scan: expected ScanSeq 1 and 2 files, got %d and %d", evAt.ScanSeq, evAt.TotalFiles)
+	}
+	if evAt := scan(); evAt.ScanSeq != 2 || len(evAt.Events) != 0 {
+		t.Errorf("idle scan: expected ScanSeq 2 and no events, got %d and %v", evAt.ScanSeq, evAt.Events)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "c.txt"), "c", then)
+	writeFile(t, filepath.Join(dir, "d.txt"), "d", then)
+	if evAt := scan(); evAt.ScanSeq != 3 || evAt.TotalFiles != 3 {
+		t.Errorf("expected ScanSeq 3 and 3 files, got %d and %d", evAt.ScanSeq, evAt.TotalFiles)
+	}
+}
+
+func TestStartedBatchesAreNumbered(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", time.Now().Add(-time.Hour))
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+
+	if evAt := receive(t, c); evAt.ScanSeq != 1 || evAt.TotalFiles != 1 {
+		t.Errorf("first scan: expected ScanSeq 1 and 1 file, got %d and %d", evAt.ScanSeq, evAt.TotalFiles)
+	}
+	ft.c <- fc.now // Idle scans aren't delivered, but still count
+	writeFile(t, filepath.Join(dir, "b.txt"), "b", fc.now)
+	if evAt := ft.tickUntil(t, fc.now, c); evAt.ScanSeq < 2 || evAt.TotalFiles != 2 {
+		t.Errorf("expected a later scan with 2 files, got ScanSeq %d with %d files", evAt.ScanSeq, evAt.TotalFiles)
+	}
+}
+
+func TestReset(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", then)
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	c := dw.AddNewObserver()
+	dw.Start()
+	fc.ticker(t)
+	receive(t, c)
+	if err := dw.Reset(); err != errRunning {
+		t.Errorf("expected Reset to refuse while running, got %v", err)
+	}
+	dw.Stop()
+	if err := dw.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, filepath.Join(dir, "b.txt"), "b", then)
+	dw.Start()
+	defer dw.Stop()
+	fc.ticker(t)
+	evAt := receive(t, c)
+	if len(evAt.Events) != 2 {
+		t.Fatalf("expected a fresh baseline of both files, got %v", evAt.Events)
+	}
+	for _, ev := range evAt.Events {
+		if ev.Type != Initial {
+			t.Errorf("unexpected event in the new baseline: %v", ev)
+		}
+	}
+}
+
+func TestPreloadModes(t *testing.T) {
+	for _, snapshot := range []bool{false, true} {
+		dir := t.TempDir()
+		then := time.Now().Add(-time.Hour)
+		writeFile(t, filepath.Join(dir, "a.txt"), "a", then)
+		writeFile(t, filepath.Join(dir, "b.txt"), "b", then)
+
+		dw := newTestWatcher(t, dir)
+		fc := newFakeClock()
+		dw.clock = fc
+		dw.Preload = true
+		dw.PreloadSnapshot = snapshot
+		c := dw.AddNewObserver()
+		dw.Start()
+		ft := fc.ticker(t)
+
+		if snapshot {
+			evAt := receive(t, c)
+			if !evAt.Baseline || len(evAt.Events) != 2 {
+				t.Fatalf("expected a baseline batch of the two existing files, got %+v", evAt)
+			}
+			for _, ev := range evAt.Events {
+				if ev.Type != Initial {
+					t.Errorf("unexpected event in baseline: %v", ev)
+				}
+			}
+		} else {
+			ft.c <- fc.now // Only accepted after the first scan
+		}
+
+		cPath := filepath.Join(dir, "c.txt")
+		writeFile(t, cPath, "c", then)
+		evAt := ft.tickUntil(t, fc.now, c)
+		if evAt.Baseline {
+			t.Errorf("later batch marked as baseline: %+v", evAt)
+		}
+		if ev := onlyEvent(t, evAt.Events); ev.Type != Added || ev.Path != cPath {
+			t.Errorf("expected only c.txt to be added, got %v", ev)
+		}
+		dw.Stop()
+	}
+}
+
+func TestPreloadSnapshotOfEmptyDirectory(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	dw.clock = newFakeClock()
+	dw.Preload = true
+	dw.PreloadSnapshot = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	if evAt := receive(t, c); !evAt.Baseline || len(evAt.Events) != 0 {
+		t.Errorf("expected an empty baseline batch, got %+v", evAt)
+	}
+}
+
+// Files found by the first scan are Initial, but only against an empty
+// snapshot: after a Stop() the first scan reports what changed meanwhile.
+func TestInitialEvents(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", then)
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	c := dw.AddNewObserver()
+	dw.Start()
+	fc.ticker(t)
+	evAt := receive(t, c)
+	if ev := onlyEvent(t, evAt.Events); !evAt.Baseline || ev.Type != Initial || ev.OldInfo != nil {
+		t.Errorf("expected a baseline with a.txt as Initial, got %+v", evAt)
+	}
+	dw.Stop()
+	<-dw.done
+
+	bPath := filepath.Join(dir, "b.txt")
+	writeFile(t, bPath, "b", then)
+	dw.Start()
+	defer dw.Stop()
+	fc.ticker(t)
+	if ev := onlyEvent(t, receive(t, c).Events); ev.Type != Added || ev.Path != bPath {
+		t.Errorf("expected b.txt to be Added after restarting, got %v", ev)
+	}
+}
+
+func TestEventsSorted(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, name := range []string{"d", "b", "f", "h"} {
+		writeFile(t, filepath.Join(dir, name), name, then)
+	}
+	dw := newTestWatcher(t, dir)
+	dw.scan2()
+
+	for _, name := range []string{"b", "h"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"g", "a", "e", "c"} {
+		writeFile(t, filepath.Join(dir, name), name, then)
+	}
+	writeFile(t, filepath.Join(dir, "f"), "ff", then.Add(time.Minute))
+
+	events := dw.batch(time.Now(), dw.scan2()).Events
+	want := []string{"a", "b", "c", "e", "f", "g", "h"}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %v", len(want), events)
+	}
+	for i, ev := range events {
+		if ev.Path != filepath.Join(dir, want[i]) {
+			t.Errorf("event %d: expected %s, got %v", i, want[i], ev)
+		}
+	}
+}
+
+func TestDeletedEventCarriesLastKnownInfo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "1", then)
+
+	dw := newTestWatcher(t, dir)
+	dw.scan2()
+	writeFile(t, path, "1234567890", then.Add(time.Minute))
+	dw.scan2()
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	ev := onlyEvent(t, dw.scan2())
+	if ev.Type != Deleted {
+		t.Fatalf("expected Deleted event, got %v", ev)
+	}
+	if ev.FileInfo == nil {
+		t.Fatal("Deleted event has no FileInfo")
+	}
+	if ev.Size() != 10 || !ev.ModTime().Equal(then.Add(time.Minute)) {
+		t.Errorf("expected the last-known size 10 and modtime %s, got %d and %s", then.Add(time.Minute), ev.Size(), ev.ModTime())
+	}
+}
+
+func TestDetectModtimeRegression(t *testing.T) {
+	for _, detect := range []bool{false, true} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "restored.txt")
+		then := time.Now().Add(-time.Hour)
+		writeFile(t, path, "old content", then)
+
+		dw := newTestWatcher(t, dir)
+		dw.DetectModtimeRegression = detect
+		// Pretend the last scan saw a newer version of the file
+		dw.files[path] = fakeInfo{"restored.txt", 11, 0644, then.Add(time.Minute)}
+
+		changed := dw.scan2()
+		if !detect {
+			if len(changed) != 0 {
+				t.Errorf("modtime regression reported without DetectModtimeRegression: %v", changed)
+			}
+			continue
+		}
+		if ev := onlyEvent(t, changed); ev.Type != Changed || !ev.ModTime().Equal(then) {
+			t.Errorf("expected a Changed event with the older modtime, got %v at %s", ev, ev.ModTime())
+		}
+		if again := dw.scan2(); len(again) != 0 {
+			t.Errorf("regression reported twice: %v", again)
+		}
+	}
+}
+
+func TestDetectSizeChanges(t *testing.T) {
+	for _, detect := range []bool{false, true} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "a.txt")
+		then := time.Now().Add(-time.Hour)
+		writeFile(t, path, "a", then)
+
+		var opts []Option
+		if detect {
+			opts = append(opts, WithStrictComparison())
+		}
+		dw, err := New(dir, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dw.DetectSizeChanges != detect || dw.DetectModtimeRegression != detect {
+			t.Fatalf("WithStrictComparison not applied: %+v", dw)
+		}
+		dw.scan2()
+
+		writeFile(t, path, "a little longer", then) // Same modtime
+		changed := dw.scan2()
+		if !detect {
+			if len(changed) != 0 {
+				t.Errorf("size change reported without DetectSizeChanges: %v", changed)
+			}
+			continue
+		}
+		if ev := onlyEvent(t, changed); ev.Type != Changed || ev.OldInfo.Size() != 1 || ev.Size() != 15 {
+			t.Errorf("expected a Changed event from 1 to 15 bytes, got %v", ev)
+		}
+	}
+}
+
+// A comparator looking at contents only: touching a file is no change, but
+// rewriting it with the same modtime is.
+func TestComparator(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "a", then)
+	contents := map[string]string{path: "a"}
+	dw, err := New(dir, WithComparator(func(old, new os.FileInfo, path string) bool {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return true
+		}
+		changed := contents[path] != string(b)
+		contents[path] = string(b)
+		return changed
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw.scan2()
+
+	writeFile(t, path, "a", then.Add(time.Minute))
+	if changed := dw.scan2(); len(changed) != 0 {
+		t.Errorf("touched file reported as changed: %v", changed)
+	}
+	writeFile(t, path, "b", then)
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Changed {
+		t.Errorf("expected a Changed event for new contents, got %v", ev)
+	}
+	if _, err := New(dir, WithComparator(nil)); err == nil {
+		t.Error("expected an error for a nil comparator")
+	}
+}
+
+func TestObserverPriority(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	names := make(map[Observer]string)
+	add := func(name string, priority int) {
+		o := NewObserver()
+		names[o] = name
+		dw.AddObserverPriority(o, priority)
+	}
+	add("reactor", 0)
+	add("logger", 10)
+	add("late reactor", 0)
+	add("metrics", 5)
+	dw.AddObserver(NewObserver()) // Same as priority 0
+
+	var got []string
+	for _, sub := range dw.observers {
+		got = append(got, names[sub.ch])
+	}
+	want := []string{"logger", "metrics", "reactor", "late reactor", ""}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected observers in order %q, got %q", want, got)
+	}
+
+	// Observers are unbuffered, so receiving out of order would block
+	go dw.send(EventsAt{At: time.Now()})
+	for _, sub := range dw.observers {
+		receive(t, sub.ch)
+	}
+}
+
+func TestDetectTruncation(t *testing.T) {
+	for _, detect := range []bool{false, true} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		then := time.Now().Add(-time.Hour)
+		writeFile(t, path, "line 1\nline 2\n", then)
+
+		dw := newTestWatcher(t, dir)
+		dw.DetectTruncation = detect
+		dw.scan2()
+
+		writeFile(t, path, "line 1\nline 2\nline 3\n", then.Add(time.Minute))
+		if ev := onlyEvent(t, dw.scan2()); ev.Type != Changed {
+			t.Errorf("expected a Changed event for a file that grew, got %v", ev)
+		}
+
+		if err := os.Truncate(path, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, then.Add(2*time.Minute), then.Add(2*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		ev := onlyEvent(t, dw.scan2())
+		want := Changed
+		if detect {
+			want = Truncated
+		}
+		if ev.Type != want {
+			t.Errorf("expected %s event, got %v", want, ev)
+		}
+		if ev.OldInfo.Size() != 21 || ev.Size() != 0 {
+			t.Errorf("expected size 21 -> 0, got %d -> %d", ev.OldInfo.Size(), ev.Size())
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.Interval = 10
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	ready := dw.Ready()
+	dw.Start()
+	defer dw.Stop()
+
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ready() not closed after the first scan")
+	}
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a", time.Now())
+	if ev := onlyEvent(t, receive(t, c).Events); ev.Type != Added || ev.Path != path {
+		t.Errorf("expected Added event for a file written after Ready(), got %v", ev)
+	}
+
+	dw.Restart()
+	if dw.Ready() == ready {
+		t.Error("Restart() reused the closed Ready() channel")
+	}
+	select {
+	case <-dw.Ready():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Ready() not closed after Restart()")
+	}
+}
+
+func TestAddObserverFiltered(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	writeFile(t, a, "a", then)
+	writeFile(t, b, "b", then)
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	all := dw.AddNewObserver()
+	deletions := make(Observer, 10)
+	dw.AddObserverFiltered(deletions, Deleted)
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	receive(t, all) // The baseline, all Added
+
+	writeFile(t, a, "aa", then.Add(time.Minute))
+	ft.tickUntil(t, fc.now, all)
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	ft.tickUntil(t, fc.now, all)
+	// Accepted once the batch has been delivered to every observer
+	ft.c <- fc.now
+
+	var got []EventsAt
+	for len(deletions) > 0 {
+		got = append(got, <-deletions)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected only the batch with the deletion, got %v", got)
+	}
+	if ev := onlyEvent(t, got[0].Events); ev.Type != Deleted || ev.Path != b {
+		t.Errorf("expected Deleted event for b.txt, got %v", ev)
+	}
+}
+
+func TestObserverCount(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	if n := dw.ObserverCount(); n != 0 {
+		t.Errorf("ObserverCount() = %d for a new watcher", n)
+	}
+	dw.AddNewObserver()
+	dw.AddObserverPriority(NewObserver(), 1)
+	dw.AddObserverFiltered(NewObserver(), Deleted)
+	if n := dw.ObserverCount(); n != 3 {
+		t.Errorf("ObserverCount() = %d, want 3", n)
+	}
+}
+
+func TestIdleWatcherSkipsScans(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.AdaptiveInterval = true // The ticker is reset after every scan
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	<-dw.Ready()
+
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a", time.Now())
+	ft.c <- fc.now
+	ft.c <- fc.now
+	select {
+	case d := <-ft.resets:
+		t.Fatalf("scanned without observers (ticker reset to %s)", d)
+	default:
+	}
+
+	c := dw.AddNewObserver()
+	if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Added || ev.Path != path {
+		t.Errorf("expected the file added while idle, got %v", ev)
+	}
+}
+
+func TestSharedObserverSource(t *testing.T) {
+	then := time.Now().Add(-time.Hour)
+	shared := make(Observer, 2)
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "same.txt"), "x", then)
+		dw := newTestWatcher(t, dir)
+		dw.clock = newFakeClock()
+		dw.AddObserver(shared)
+		dw.Start()
+		defer dw.Stop()
+		dirs = append(dirs, dir)
+	}
+
+	got := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		evAt := receive(t, shared)
+		if ev := onlyEvent(t, evAt.Events); ev.Path != filepath.Join(evAt.Source, "same.txt") {
+			t.Errorf("event %v not under its batch's Source %s", ev, evAt.Source)
+		}
+		got[evAt.Source] = true
+	}
+	if !got[dirs[0]] || !got[dirs[1]] {
+		t.Errorf("expected a batch from each of %v, got sources %v", dirs, got)
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	for _, backend := range []Backend{Polling, Native} {
+		dw := newTestWatcher(t, t.TempDir())
+		dw.Backend = backend
+		ctx, cancel := context.WithCancel(context.Background())
+		dw.StartContext(ctx)
+		<-dw.Ready()
+		if !dw.Running() {
+			t.Fatal("watcher not running after StartContext()")
+		}
+		cancel()
+		select {
+		case <-dw.done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("backend %d: goroutine still running after the context was cancelled", backend)
+		}
+		if dw.Running() {
+			t.Errorf("backend %d: Running() after the context was cancelled", backend)
+		}
+	}
+}
+
+func TestDetectAttrChanges(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.sh")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "#!/bin/sh", then)
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dw := newTestWatcher(t, dir)
+	dw.DetectAttrChanges = true
+	dw.DetectModeChanges = true // Superseded
+	dw.scan2()
+
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ev := onlyEvent(t, dw.scan2())
+	if ev.Type != AttrChanged || ev.OldInfo.Mode().Perm() != 0644 || ev.Mode().Perm() != 0755 {
+		t.Errorf("expected AttrChanged from 0644 to 0755, got %v", ev)
+	}
+
+	if os.Geteuid() == 0 {
+		if err := os.Chown(path, 65534, 65534); err != nil {
+			t.Fatal(err)
+		}
+		if ev := onlyEvent(t, dw.scan2()); ev.Type != AttrChanged {
+			t.Errorf("expected AttrChanged for chown, got %v", ev)
+		}
+	}
+
+	// A chmod along with a write is just a change
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, "#!/bin/bash", then.Add(time.Minute))
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Changed {
+		t.Errorf("expected Changed, got %v", ev)
+	}
+}
+
+func TestNewFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.Mkdir("conf", 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("conf", "app.yaml")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join("conf", "other.yaml"), "x", then)
+
+	dw, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := dw.scan2(); len(events) != 0 {
+		t.Errorf("expected nothing before the file exists, got %v", events)
+	}
+	writeFile(t, path, "a: 1", then)
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Added || ev.Path != path {
+		t.Errorf("expected Added event for %s, got %v", path, ev)
+	}
+	writeFile(t, path, "a: 2", then.Add(time.Minute))
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Changed {
+		t.Errorf("expected Changed event, got %v", ev)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Deleted {
+		t.Errorf("expected Deleted event, got %v", ev)
+	}
+
+	if _, err := NewFile("conf"); err == nil {
+		t.Error("expected an error for a directory")
+	}
+	if _, err := NewFile(filepath.Join("nowhere", "app.yaml")); err == nil {
+		t.Error("expected an error for a file in a missing directory")
+	}
+}
+
+// Run with -race: the methods are meant to be used from several goroutines.
+func TestConcurrentUse(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.Interval = 1
+	dw.DeliveryTimeout = time.Millisecond
+	c := dw.AddNewObserver()
+	go func() {
+		for range c {
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				switch (i + j) % 4 {
+				case 0:
+					dw.Start()
+				case 1:
+					dw.Stop()
+				case 2:
+					o := dw.AddNewObserver()
+					dw.Running()
+					dw.RemoveObserver(o)
+				case 3:
+					dw.Pause()
+					dw.SetInterval(time.Duration(j+1) * time.Millisecond)
+					dw.Resume()
+				}
+				writeFile(t, filepath.Join(dir, fmt.Sprintf("%d.txt", i)), strings.Repeat("x", j), time.Now())
+			}
+		}(i)
+	}
+	wg.Wait()
+	dw.Restart()
+	dw.Stop()
+	<-dw.exited()
+	dw.UnsubscribeAll(true)
+}
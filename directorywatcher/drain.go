@@ -0,0 +1,52 @@
+package directorywatcher
+
+import "sync"
+
+// A bounded buffer of events for Drain(). When full, the oldest events are
+// overwritten and counted as dropped.
+type eventRing struct {
+	mu      sync.Mutex
+	buf     []Event
+	start   int // Index of the oldest event
+	n       int // Number of buffered events
+	dropped uint64
+}
+
+// Buffers events, allocating the buffer with the given capacity on first use.
+func (r *eventRing) push(capacity int, events []Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.buf == nil {
+		r.buf = make([]Event, capacity)
+	}
+	for _, ev := range events {
+		if r.n == len(r.buf) {
+			r.buf[r.start] = ev
+			r.start = (r.start + 1) % len(r.buf)
+			r.dropped++
+			continue
+		}
+		r.buf[(r.start+r.n)%len(r.buf)] = ev
+		r.n++
+	}
+}
+
+func (r *eventRing) drain() (events []Event, dropped uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < r.n; i++ {
+		events = append(events, r.buf[(r.start+i)%len(r.buf)])
+		r.buf[(r.start+i)%len(r.buf)] = Event{}
+	}
+	dropped = r.dropped
+	r.start, r.n, r.dropped = 0, 0, 0
+	return
+}
+
+// Returns the events accumulated since the last call, oldest first, for
+// consumers that would rather poll than read from an observer channel. Only
+// the latest DrainCapacity events are kept; dropped is the number of older
+// ones that had to make room. Without a DrainCapacity, nothing is accumulated.
+func (dw *directoryWatcher) Drain() (events []Event, dropped uint64) {
+	return dw.pull.drain()
+}
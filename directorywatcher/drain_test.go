@@ -0,0 +1,72 @@
+package directorywatcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.DrainCapacity = 10
+	then := time.Now().Add(-time.Hour)
+
+	writeFile(t, filepath.Join(dir, "a"), "a", then)
+	writeFile(t, filepath.Join(dir, "b"), "b", then)
+	dw.notify(dw.batch(then, dw.scan2()))
+	writeFile(t, filepath.Join(dir, "a"), "aa", then.Add(time.Minute))
+	dw.notify(dw.batch(then, dw.scan2()))
+
+	events, dropped := dw.Drain()
+	if dropped != 0 {
+		t.Errorf("expected nothing dropped, got %d", dropped)
+	}
+	want := []struct {
+		typ  eventType
+		name string
+	}{{Added, "a"}, {Added, "b"}, {Changed, "a"}}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %v", len(want), events)
+	}
+	for i, w := range want {
+		if events[i].Type != w.typ || events[i].Name() != w.name {
+			t.Errorf("event %d: expected %s %s, got %v", i, w.typ, w.name, events[i])
+		}
+	}
+
+	if events, _ := dw.Drain(); len(events) != 0 {
+		t.Errorf("second Drain() returned %v", events)
+	}
+}
+
+func TestDrainDropsOldest(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.DrainCapacity = 2
+	then := time.Now().Add(-time.Hour)
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		writeFile(t, filepath.Join(dir, name), name, then)
+		dw.notify(dw.batch(then, dw.scan2()))
+	}
+
+	events, dropped := dw.Drain()
+	if dropped != 3 {
+		t.Errorf("expected 3 dropped events, got %d", dropped)
+	}
+	if len(events) != 2 || events[0].Name() != "d" || events[1].Name() != "e" {
+		t.Errorf("expected the two newest events, got %v", events)
+	}
+}
+
+func TestDrainDisabled(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	writeFile(t, filepath.Join(dir, "a"), "a", time.Now())
+	dw.notify(dw.batch(time.Now(), dw.scan2()))
+
+	if events, dropped := dw.Drain(); len(events) != 0 || dropped != 0 {
+		t.Errorf("expected nothing without DrainCapacity, got %v (%d dropped)", events, dropped)
+	}
+}
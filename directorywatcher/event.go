@@ -1,6 +1,7 @@
 package directorywatcher
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -12,13 +13,21 @@ const (
 	Added eventType = iota
 	Changed
 	Deleted
+	Truncated
+	Renamed
+	AttrChanged
+	Initial
 )
 
 // Mapping event types to a string, for implementing Stringer interface
 var eventNames = map[eventType]string{
-	Added:   "Added",
-	Changed: "Changed",
-	Deleted: "Deleted",
+	Added:       "Added",
+	Changed:     "Changed",
+	Deleted:     "Deleted",
+	Truncated:   "Truncated",
+	Renamed:     "Renamed",
+	AttrChanged: "AttrChanged",
+	Initial:     "Initial",
 }
 
 // eventType implements Stringer
@@ -31,16 +40,54 @@ func (e Event) String() string {
 	return fmt.Sprintf("%s %s", eventNames[e.Type], e.Path)
 }
 
-// An event contains its type and the file involved.
+// An event contains its type and the file involved. Initial is for the files
+// found by the first scan, which were already there rather than just created.
+// For Changed, Truncated, Deleted, Renamed and AttrChanged events, OldInfo
+// holds the FileInfo the file had at the previous scan; it is nil for Added
+// and Initial events. OldPath is where a Renamed file was before, and empty
+// for any other event.
 type Event struct {
 	Type eventType
 	Path string
 	os.FileInfo
+	OldInfo os.FileInfo
+	OldPath string
+}
+
+// Reported (as the Err of a ScanError) when the watched directory is removed.
+var ErrRootRemoved = errors.New("Watched directory was removed")
+
+// A ScanError describes a file or directory that couldn't be scanned.
+type ScanError struct {
+	Path string // The file or directory involved
+	Op   string // The operation that failed, such as "stat" or "open"
+	Err  error
+}
+
+func (e *ScanError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
 }
 
 // EventsAt contains a list of events (one for each file that changed) and a
-// timestamp. 
+// timestamp.
+//
+// ScanSeq numbers the scans of a watcher, starting from 1, and TotalFiles is
+// the number of files tracked after the scan. Baseline is set on the batch of
+// the first scan, whose events are the files that were already there. Source
+// is the directory the watcher watches, so batches of several watchers sent to
+// the same observer can be told apart.
 type EventsAt struct {
-	At     time.Time
-	Events []Event
+	At         time.Time `json:"at"`
+	Events     []Event   `json:"events"`
+	ScanSeq    uint64    `json:"scanSeq"`
+	TotalFiles int       `json:"totalFiles"`
+	Baseline   bool      `json:"baseline,omitempty"`
+	Source     string    `json:"source,omitempty"`
 }
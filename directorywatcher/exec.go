@@ -0,0 +1,152 @@
+package directorywatcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExecMode is what a Command does about changes that come in while it runs.
+type ExecMode int
+
+const (
+	Queue   ExecMode = iota // Let it finish, then run once more for all of them
+	Restart                 // Kill it, and run again right away
+)
+
+// The environment variable that holds the changed paths, with PathsInEnv,
+// separated by os.PathListSeparator.
+const PathsEnv = "DIRWATCHER_PATHS"
+
+// A Command is run by ExecCommand whenever files change. Runs never overlap.
+type Command struct {
+	Name string
+	Args []string
+	Dir  string // The working directory, the current one if empty
+
+	Mode ExecMode
+
+	// Pass the paths that changed since the last run in the PathsEnv
+	// environment variable, and/or as extra arguments after Args.
+	PathsInEnv  bool
+	PathsAsArgs bool
+
+	// Where the command's output goes, os.Stdout and os.Stderr if nil.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Runs name with args whenever files change, one run at a time, see
+// ExecCommand.
+func (dw *directoryWatcher) Exec(name string, args ...string) (stop func()) {
+	return dw.ExecCommand(Command{Name: name, Args: args})
+}
+
+// Runs cmd whenever files change. Changes that come in while it runs are
+// collected for the next run, which starts once the current one exits (or is
+// killed, with Restart). Commands that can't be started are reported on
+// Errors(); how they exit isn't. Calling the returned stop detaches the
+// command, killing a run in progress, and returns once it has exited.
+func (dw *directoryWatcher) ExecCommand(cmd Command) (stop func()) {
+	o := NewObserver()
+	dw.AddObserver(o)
+	quit, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		dw.execLoop(cmd, o, quit)
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			dw.RemoveObserver(o)
+			close(quit)
+			<-done
+		})
+	}
+}
+
+func (dw *directoryWatcher) execLoop(cmd Command, o Observer, quit <-chan struct{}) {
+	pending := make(map[string]bool) // Paths changed since the last run started
+	var kill context.CancelFunc      // Kills the current run, nil if there is none
+	var exited chan struct{}
+	start := func() {
+		var paths []string
+		for p := range pending {
+			paths = append(paths, p)
+			delete(pending, p)
+		}
+		sort.Strings(paths)
+		var ctx context.Context
+		ctx, kill = context.WithCancel(context.Background())
+		c := cmd.command(ctx, paths)
+		if err := c.Start(); err != nil {
+			dw.reportError(err)
+			kill()
+			kill = nil
+			return
+		}
+		exited = make(chan struct{})
+		go func(exited chan struct{}) {
+			c.Wait()
+			close(exited)
+		}(exited)
+	}
+	for {
+		select {
+		case evAt, ok := <-o:
+			if !ok {
+				o = nil // Closed by UnsubscribeAll, wait for quit
+				continue
+			}
+			for _, ev := range evAt.Events {
+				pending[ev.Path] = true
+			}
+			switch {
+			case len(pending) == 0:
+			case kill == nil:
+				start()
+			case cmd.Mode == Restart:
+				kill() // Started again once it has exited
+			}
+		case <-exited:
+			kill()
+			kill, exited = nil, nil
+			if len(pending) > 0 {
+				start()
+			}
+		case <-quit:
+			if kill != nil {
+				kill()
+				<-exited
+			}
+			return
+		}
+	}
+}
+
+// The command for a run on behalf of paths.
+func (cmd Command) command(ctx context.Context, paths []string) *exec.Cmd {
+	args := cmd.Args
+	if cmd.PathsAsArgs {
+		args = append(append([]string(nil), args...), paths...)
+	}
+	c := exec.CommandContext(ctx, cmd.Name, args...)
+	c.WaitDelay = time.Second // For children of a killed run holding on to its output
+	c.Dir = cmd.Dir
+	c.Stdout, c.Stderr = cmd.Stdout, cmd.Stderr
+	if c.Stdout == nil {
+		c.Stdout = os.Stdout
+	}
+	if c.Stderr == nil {
+		c.Stderr = os.Stderr
+	}
+	if cmd.PathsInEnv {
+		c.Env = append(os.Environ(), PathsEnv+"="+strings.Join(paths, string(os.PathListSeparator)))
+	}
+	return c
+}
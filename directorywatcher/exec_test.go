@@ -0,0 +1,96 @@
+package directorywatcher
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waits for the file at path to have the given contents.
+func waitForContents(t *testing.T, path, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var got []byte
+	for time.Now().Before(deadline) {
+		got, _ = os.ReadFile(path)
+		if string(got) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %q in %s, got %q", want, path, got)
+}
+
+func changes(paths ...string) EventsAt {
+	evAt := EventsAt{}
+	for _, p := range paths {
+		evAt.Events = append(evAt.Events, Event{Changed, p, nil, nil, ""})
+	}
+	return evAt
+}
+
+func TestExecCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	dw := newTestWatcher(t, dir)
+	stop := dw.ExecCommand(Command{
+		Name:        "sh",
+		Args:        []string{"-c", `echo "$DIRWATCHER_PATHS $*" >> out`, "sh"},
+		Dir:         dir,
+		PathsInEnv:  true,
+		PathsAsArgs: true,
+	})
+	defer stop()
+
+	dw.send(changes("b", "a"))
+	sep := string(os.PathListSeparator)
+	waitForContents(t, out, "a"+sep+"b a b\n")
+	dw.send(changes("c"))
+	waitForContents(t, out, "a"+sep+"b a b\nc c\n")
+}
+
+func TestExecRestart(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	dw := newTestWatcher(t, dir)
+	for _, mode := range []ExecMode{Queue, Restart} {
+		os.Remove(log)
+		stop := dw.ExecCommand(Command{
+			Name: "sh",
+			Args: []string{"-c", `echo start >> log; sleep 0.3; echo end >> log`},
+			Dir:  dir,
+			Mode: mode,
+		})
+		dw.send(changes("a"))
+		waitForContents(t, log, "start\n")
+		if mode == Queue {
+			dw.send(changes("b"))
+			dw.send(changes("c")) // Collected along with b, while the first run goes on
+		} else {
+			dw.send(changes("b", "c"))
+		}
+		if mode == Queue {
+			waitForContents(t, log, "start\nend\nstart\nend\n")
+		} else {
+			waitForContents(t, log, "start\nstart\n")
+		}
+		stop()
+		time.Sleep(400 * time.Millisecond)
+		got, _ := os.ReadFile(log)
+		if runs := strings.Count(string(got), "start"); runs != 2 {
+			t.Errorf("mode %d: expected 2 runs, got %q", mode, got)
+		}
+		if mode == Restart && strings.Contains(string(got), "end") {
+			t.Errorf("expected every run killed, got %q", got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package directorywatcher
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Whether a slash-separated pattern matches name, a path using the OS's
+// separator. Each segment of the pattern matches one segment of name, as by
+// path.Match, except that a "**" segment matches any number of them
+// (including none), so "src/**/*.go" matches "src/a.go" and "src/x/y/b.go".
+func matchPath(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(filepath.ToSlash(name), "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// Whether pattern could match something below the directory dir, that is,
+// whether dir matches the first segments of pattern.
+func matchPrefix(pattern, dir string) bool {
+	segs := strings.Split(pattern, "/")
+	for _, part := range strings.Split(filepath.ToSlash(dir), "/") {
+		if len(segs) <= 1 {
+			return false // What's left can only match dir itself
+		}
+		if segs[0] == "**" {
+			return true
+		}
+		if ok, err := path.Match(segs[0], part); err != nil || !ok {
+			return false
+		}
+		segs = segs[1:]
+	}
+	return true
+}
+
+// Returns path.ErrBadPattern if any segment of pattern is malformed.
+func checkPath(pattern string) error {
+	for _, seg := range strings.Split(pattern, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package directorywatcher
+
+import (
+	"sync"
+	"time"
+)
+
+// A bounded buffer of batches for History(). When full, the oldest batch is
+// overwritten.
+type batchRing struct {
+	mu    sync.Mutex
+	buf   []EventsAt
+	start int // Index of the oldest batch
+	n     int // Number of buffered batches
+}
+
+// Buffers a batch, allocating the buffer with the given capacity on first use.
+func (r *batchRing) push(capacity int, evAt EventsAt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.buf == nil {
+		r.buf = make([]EventsAt, capacity)
+	}
+	if r.n == len(r.buf) {
+		r.buf[r.start] = evAt
+		r.start = (r.start + 1) % len(r.buf)
+		return
+	}
+	r.buf[(r.start+r.n)%len(r.buf)] = evAt
+	r.n++
+}
+
+func (r *batchRing) since(t time.Time) (batches []EventsAt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < r.n; i++ {
+		if evAt := r.buf[(r.start+i)%len(r.buf)]; evAt.At.After(t) {
+			batches = append(batches, evAt)
+		}
+	}
+	return
+}
+
+// Returns the batches delivered after since, oldest first, so an observer that
+// started late or reconnects can catch up on what changed, e.g.
+//
+//	dw.History(time.Now().Add(-5 * time.Minute))
+//
+// Only the latest HistorySize batches with events are kept (heartbeats
+// aren't), whether or not they were read from Drain() or by observers. Unlike
+// Drain(), History() doesn't consume anything. The batches share their
+// Events with what observers received, so they shouldn't be modified.
+func (dw *directoryWatcher) History(since time.Time) []EventsAt {
+	return dw.history.since(since)
+}
@@ -0,0 +1,38 @@
+package directorywatcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.HistorySize = 2
+	dw.HeartbeatEvery = time.Nanosecond
+	then := time.Now().Add(-time.Hour)
+
+	for i, name := range []string{"a", "b", "c"} {
+		writeFile(t, filepath.Join(dir, name), name, then)
+		dw.notify(dw.batch(then.Add(time.Duration(i)*time.Minute), dw.scan2()))
+	}
+	dw.notify(dw.batch(then.Add(3*time.Minute), dw.scan2())) // A heartbeat
+
+	got := dw.History(time.Time{})
+	if len(got) != 2 {
+		t.Fatalf("expected the latest 2 batches, got %v", got)
+	}
+	if ev := onlyEvent(t, got[0].Events); ev.Name() != "b" {
+		t.Errorf("expected the batch adding b first, got %v", ev)
+	}
+	if got := dw.History(then.Add(time.Minute)); len(got) != 1 || got[0].Events[0].Name() != "c" {
+		t.Errorf("expected only the batch adding c after 1m, got %v", got)
+	}
+	if again := dw.History(time.Time{}); len(again) != 2 {
+		t.Errorf("History() consumed batches: %v", again)
+	}
+	if dw.idle() {
+		t.Error("watcher with a HistorySize is idle")
+	}
+}
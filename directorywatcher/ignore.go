@@ -0,0 +1,152 @@
+package directorywatcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// One line of a .gitignore, or a pattern given to Ignore
+type ignoreRule struct {
+	pattern  string
+	negate   bool // Starts with "!": un-ignores what earlier rules ignored
+	dirOnly  bool // Ends with "/": only matches directories
+	anchored bool // Contains a "/": matched against the path below the root, not just the name
+}
+
+// Parses a pattern the way git does for .gitignore.
+func parseIgnore(pattern string) (rule ignoreRule, err error) {
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate, pattern = true, pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) {
+		pattern = pattern[1:] // Escapes a leading "!" or "#"
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly, pattern = true, strings.TrimRight(pattern, "/")
+	}
+	if strings.Contains(pattern, "/") {
+		rule.anchored, pattern = true, strings.TrimPrefix(pattern, "/")
+	}
+	if pattern == "" {
+		return rule, fmt.Errorf("Empty ignore pattern")
+	}
+	if err := checkPath(pattern); err != nil {
+		return rule, fmt.Errorf("Invalid ignore pattern %q: %v", pattern, err)
+	}
+	rule.pattern = pattern
+	return rule, nil
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return matchPath(r.pattern, rel)
+	}
+	return matches(r.pattern, filepath.Base(rel))
+}
+
+// Don't watch, or even scan, the files and directories matching any of the
+// patterns, which work as in a .gitignore: a pattern without a slash matches
+// names anywhere in the tree ("node_modules", "*.tmp"), one with a slash
+// matches the path below the watched directory (".git/**", "build/*.o"), a
+// trailing slash only matches directories, and a leading "!" takes back
+// an earlier pattern for what it matches. Within an ignored directory,
+// nothing is watched.
+//
+// Like the fields, patterns are added before Start: while the watcher is
+// running, Ignore returns an error.
+func (dw *directoryWatcher) Ignore(patterns ...string) error {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	if dw.running() {
+		return errRunning
+	}
+	dw.wait()
+	for _, p := range patterns {
+		rule, err := parseIgnore(p)
+		if err != nil {
+			return err
+		}
+		dw.ignores = append(dw.ignores, rule)
+	}
+	dw.compileIgnores()
+	return nil
+}
+
+// Puts together the rules for each watched directory: those of its .gitignore
+// (with Gitignore set, and if there is one), then those given to Ignore.
+// Nested .gitignore files aren't read.
+func (dw *directoryWatcher) compileIgnores() {
+	dw.rules = nil
+	if len(dw.ignores) == 0 && !dw.Gitignore {
+		return
+	}
+	dw.rules = make(map[string][]ignoreRule)
+	for _, root := range dw.roots {
+		var rules []ignoreRule
+		if dw.Gitignore {
+			var err error
+			rules, err = readIgnoreFile(filepath.Join(root.path, ".gitignore"))
+			if err != nil && !os.IsNotExist(err) {
+				dw.reportError(err)
+			}
+		}
+		dw.rules[root.path] = append(rules, dw.ignores...)
+	}
+}
+
+func readIgnoreFile(path string) (rules []ignoreRule, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// A bad line is skipped, as git does
+		if rule, err := parseIgnore(line); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, s.Err()
+}
+
+// Whether path is ignored, either itself or by being in an ignored directory.
+// The root directories themselves never are.
+func (dw *directoryWatcher) ignored(path string, isDir bool) bool {
+	if dw.rules == nil {
+		return false
+	}
+	root := dw.rootOf(path)
+	rules := dw.rules[root.path]
+	rel, err := filepath.Rel(root.path, path)
+	if err != nil || rel == "." || len(rules) == 0 {
+		return false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i := range parts {
+		last := i == len(parts)-1
+		if ignoredBy(rules, filepath.Join(parts[:i+1]...), !last || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// The last rule matching rel decides, as in git.
+func ignoredBy(rules []ignoreRule, rel string, isDir bool) (ignored bool) {
+	for _, r := range rules {
+		if r.match(rel, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return
+}
@@ -0,0 +1,241 @@
+package directorywatcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+)
+
+// The paths tracked after a scan, relative to dir.
+func trackedFiles(t *testing.T, dw *directoryWatcher, dir string) (rel []string) {
+	t.Helper()
+	for p := range dw.files {
+		r, err := filepath.Rel(dir, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	sort.Strings(rel)
+	return
+}
+
+func TestIgnore(t *testing.T) {
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, p := range []string{
+		"a.go", "a.tmp", "keep.tmp",
+		"node_modules/x/y.js", ".git/HEAD", "src/node_modules",
+		"build/out.o", "build/sub/out.o", "build/README",
+	} {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, p, "x", then)
+	}
+
+	for _, parallel := range []bool{false, true} {
+		dw := newTestWatcher(t, root)
+		dw.Recursive = true
+		dw.ParallelScan = parallel
+		if err := dw.Ignore("node_modules/", "*.tmp", "!keep.tmp", ".git/**", "build/*.o"); err != nil {
+			t.Fatal(err)
+		}
+		dw.selectScanner()
+		scanWithin(t, dw, time.Second)
+
+		got := trackedFiles(t, dw, root)
+		want := []string{"a.go", "build/README", "build/sub/out.o", "keep.tmp", "src/node_modules"}
+		if len(got) != len(want) {
+			t.Fatalf("parallel=%v: expected %v tracked, got %v", parallel, want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("parallel=%v: expected %v tracked, got %v", parallel, want, got)
+				break
+			}
+		}
+	}
+
+	dw := newTestWatcher(t, root)
+	if err := dw.Ignore("[a-"); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(root, ".gitignore"), "# build output\n*.log\n\n/vendor/\n\\#odd\n", then)
+	for _, p := range []string{"a.go", "debug.log", "#odd", "vendor/lib.go", "sub/vendor/lib.go"} {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, p, "x", then)
+	}
+
+	dw, err := New(root, WithRecursive(true), WithGitignore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanWithin(t, dw, time.Second)
+	got := trackedFiles(t, dw, root)
+	want := []string{".gitignore", "a.go", "sub/vendor/lib.go"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("expected %v tracked, got %v", want, got)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"src/**/*.go", "src/a.go", true},
+		{"src/**/*.go", "src/x/y/b.go", true},
+		{"src/**/*.go", "lib/a.go", false},
+		{"src/**/*.go", "src/x/a.txt", false},
+		{"**/*.go", "a.go", true},
+		{".git/**", ".git/objects/ab", true},
+		{"*.go", "sub/a.go", false},
+		{"sub/*", "sub/a/b", false},
+	} {
+		if got := matchPath(tc.pattern, filepath.FromSlash(tc.name)); got != tc.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		pattern, dir string
+		want         bool
+	}{
+		{"src/**/*.go", "src", true},
+		{"src/**/*.go", "src/x/y", true},
+		{"src/**/*.go", "lib", false},
+		{"src/*.go", "src/x", false},
+		{"*/a.go", "x", true},
+	} {
+		if got := matchPrefix(tc.pattern, filepath.FromSlash(tc.dir)); got != tc.want {
+			t.Errorf("matchPrefix(%q, %q) = %v, want %v", tc.pattern, tc.dir, got, tc.want)
+		}
+	}
+}
+
+func TestPathPattern(t *testing.T) {
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, p := range []string{"a.go", "src/b.go", "src/x/y/c.go", "src/x/d.txt", "lib/e.go"} {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, p, "x", then)
+	}
+	if err := os.Chmod(filepath.Join(root, "lib"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(root, "lib"), 0755)
+
+	dw, err := New(root, WithRecursive(true), WithPattern("src/**/*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanWithin(t, dw, time.Second)
+	got := trackedFiles(t, dw, root)
+	if len(got) != 2 || got[0] != "src/b.go" || got[1] != "src/x/y/c.go" {
+		t.Errorf("expected the .go files below src tracked, got %v", got)
+	}
+	// lib isn't even read, so it being unreadable goes unnoticed
+	if errs := scanErrors(dw); len(errs) != 0 {
+		t.Errorf("directory outside the pattern was scanned: %v", errs)
+	}
+
+	if _, err := New(root, WithPattern("src/[a-/*.go")); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, p := range []string{"Makefile", "go.mod", "main.go", "README.md", "src/b.go", "src/b.txt", "docs/x.md", "lib/e.go"} {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, p, "x", then)
+	}
+	tracked := func(patterns ...string) []string {
+		dw, err := New(root, WithRecursive(true), WithPatterns(patterns...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanWithin(t, dw, time.Second)
+		return trackedFiles(t, dw, root)
+	}
+	if got := fmt.Sprint(tracked("*.go", "go.mod", "Makefile")); got != "[Makefile go.mod lib/e.go main.go src/b.go]" {
+		t.Errorf("expected the files matching any of the patterns, got %v", got)
+	}
+
+	// Only path patterns, so lib isn't read
+	if err := os.Chmod(filepath.Join(root, "lib"), 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(root, "lib"), 0755)
+	dw, err := New(root, WithRecursive(true), WithPatterns("src/*.go", "docs/*.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanWithin(t, dw, time.Second)
+	if got := fmt.Sprint(trackedFiles(t, dw, root)); got != "[docs/x.md src/b.go]" {
+		t.Errorf("expected the files matching either path pattern, got %v", got)
+	}
+	if errs := scanErrors(dw); len(errs) != 0 {
+		t.Errorf("directory outside the patterns was scanned: %v", errs)
+	}
+
+	if _, err := New(root, WithPatterns("*.go", "[")); err == nil {
+		t.Error("expected an error for a malformed pattern")
+	}
+}
+
+func TestWithRegexp(t *testing.T) {
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, p := range []string{"a_gen.go", "cmd/x/b_gen.go", "cmd/x/b.go", "internal/c_gen.go", "lib/d_gen.go", "notes.txt"} {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, p, "x", then)
+	}
+
+	dw, err := New(root, WithRecursive(true), WithRegexp(regexp.MustCompile(`^(cmd|internal)/.*_gen\.go$`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanWithin(t, dw, time.Second)
+	if got := fmt.Sprint(trackedFiles(t, dw, root)); got != "[cmd/x/b_gen.go internal/c_gen.go]" {
+		t.Errorf("expected the generated files below cmd and internal, got %v", got)
+	}
+
+	// Several regexps match any of them, still within Pattern
+	dw, err = New(root, WithRecursive(true), WithPattern("*.go"),
+		WithRegexp(regexp.MustCompile(`^lib/`)), WithRegexp(regexp.MustCompile(`^[^/]*$`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanWithin(t, dw, time.Second)
+	if got := fmt.Sprint(trackedFiles(t, dw, root)); got != "[a_gen.go lib/d_gen.go]" {
+		t.Errorf("expected the Go files matching either regexp, got %v", got)
+	}
+
+	if _, err := New(root, WithRegexp(nil)); err == nil {
+		t.Error("expected an error for a nil regexp")
+	}
+}
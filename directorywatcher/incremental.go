@@ -0,0 +1,83 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How recently a directory may have been modified for its listing to be
+// trusted: within a modtime's granularity, a change right after the listing
+// wouldn't move it.
+const modtimeGranularity = 2 * time.Second
+
+// A listed directory, as incrementalScanner remembers it.
+type listedDir struct {
+	modTime time.Time
+	names   []string // Sorted, as by os.ReadDir
+}
+
+// Finds the same files as recScanner, in the same order, but doesn't list a
+// directory again while its modtime stays the same. Files only change their
+// directory's modtime when they're created, removed or renamed, so those in an
+// unchanged directory are still stat'ed one by one, and changes to them are
+// seen as usual. What's saved is reading the directories.
+func incrementalScanner(buffer int, skip skipFn, report errorFn) ScanFunc {
+	listed := make(map[string]map[string]listedDir) // By root, as each has a scan of its own
+	return func(path string) <-chan StrFileInfo {
+		c := make(chan StrFileInfo, buffer)
+		go func() {
+			defer close(c)
+			info, err := os.Lstat(path)
+			if err != nil {
+				report(err)
+				return
+			}
+			c <- wrapFn(path, info)
+			if !info.IsDir() {
+				return
+			}
+			fresh := make(map[string]listedDir) // Only the directories still there
+			trusted := time.Now().Add(-modtimeGranularity)
+
+			var walk func(dir string, info os.FileInfo)
+			walk = func(dir string, info os.FileInfo) {
+				l, ok := listed[path][dir]
+				if !ok || !l.modTime.Equal(info.ModTime()) {
+					entries, err := os.ReadDir(dir)
+					if err != nil {
+						report(err)
+						return
+					}
+					l = listedDir{info.ModTime(), make([]string, len(entries))}
+					for i, entry := range entries {
+						l.names[i] = entry.Name()
+					}
+				}
+				if l.modTime.Before(trusted) {
+					fresh[dir] = l
+				}
+				for _, name := range l.names {
+					p := filepath.Join(dir, name)
+					info, err := os.Lstat(p)
+					if err != nil {
+						if !os.IsNotExist(err) {
+							report(err)
+						}
+						continue
+					}
+					if skip(p, info.IsDir()) {
+						continue
+					}
+					c <- wrapFn(p, linkInfo(p, info))
+					if info.IsDir() {
+						walk(p, info)
+					}
+				}
+			}
+			walk(path, info)
+			listed[path] = fresh
+		}()
+		return c
+	}
+}
@@ -0,0 +1,93 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIncrementalScan(t *testing.T) {
+	root := t.TempDir()
+	nestedTree(t, root, 1, 2)
+	sub := filepath.Join(root, "d1")
+	old := time.Now().Add(-time.Hour)
+	for _, dir := range []string{root, sub} {
+		if err := os.Chtimes(dir, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dw := newTestWatcher(t, root)
+	dw.Recursive = true
+	dw.IncrementalScan = true
+	dw.selectScanner()
+	dw.scan2()
+	if len(dw.files) != 2+4 {
+		t.Fatalf("expected 6 files, got %d", len(dw.files))
+	}
+
+	// A directory whose modtime stayed the same isn't listed again, but the
+	// files known in it are still stat'ed.
+	hidden := filepath.Join(sub, "new.txt")
+	writeFile(t, hidden, "x", old)
+	known := filepath.Join(sub, "f0.txt")
+	writeFile(t, known, "changed", time.Now().Add(time.Hour))
+	if err := os.Chtimes(sub, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Changed || ev.Path != known {
+		t.Errorf("expected only %s to change, got %v", known, ev)
+	}
+
+	// Once its modtime moves, it's listed again
+	if err := os.Chtimes(sub, old.Add(time.Minute), old.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Added || ev.Path != hidden {
+		t.Errorf("expected %s to be added, got %v", hidden, ev)
+	}
+	if err := os.Remove(hidden); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Deleted || ev.Path != hidden {
+		t.Errorf("expected %s to be deleted, got %v", hidden, ev)
+	}
+}
+
+// Every watched directory keeps its own listings, so scanning one doesn't
+// throw away what was listed in the other.
+func TestIncrementalScanRoots(t *testing.T) {
+	roots := []string{t.TempDir(), t.TempDir()}
+	old := time.Now().Add(-time.Hour)
+	for _, root := range roots {
+		nestedTree(t, root, 1, 2)
+		for _, dir := range []string{root, filepath.Join(root, "d1")} {
+			if err := os.Chtimes(dir, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	dw := newTestWatcher(t, roots[0])
+	if err := dw.AddPath(roots[1]); err != nil {
+		t.Fatal(err)
+	}
+	dw.Recursive = true
+	dw.IncrementalScan = true
+	dw.selectScanner()
+	dw.scan2()
+	if len(dw.files) != 2*(2+4) {
+		t.Fatalf("expected 12 files, got %d", len(dw.files))
+	}
+
+	// Files slipped into unchanged directories stay unseen in both
+	for _, root := range roots {
+		sub := filepath.Join(root, "d1")
+		writeFile(t, filepath.Join(sub, "new.txt"), "x", old)
+		if err := os.Chtimes(sub, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events := dw.scan2(); len(events) != 0 {
+		t.Errorf("expected the unchanged directories not to be listed again, got %v", events)
+	}
+}
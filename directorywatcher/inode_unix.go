@@ -0,0 +1,26 @@
+//go:build unix
+
+package directorywatcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// The device and inode of a file, if its FileInfo came from the OS.
+func fileID(info os.FileInfo) (id [2]uint64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return id, false
+	}
+	return [2]uint64{uint64(st.Dev), uint64(st.Ino)}, true
+}
+
+// The owner and group of a file, if its FileInfo came from the OS.
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return st.Uid, st.Gid, true
+}
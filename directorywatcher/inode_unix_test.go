@@ -0,0 +1,33 @@
+//go:build unix
+
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplacedFileIsChanged(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "{}", then)
+
+	dw := newTestWatcher(t, dir)
+	dw.scan2()
+
+	// Same size and modtime, but a new inode
+	tmp := filepath.Join(t.TempDir(), "config.json")
+	writeFile(t, tmp, "[]", then)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Changed || ev.Path != path {
+		t.Errorf("expected Changed event for the replaced file, got %v", ev)
+	}
+	if again := dw.scan2(); len(again) != 0 {
+		t.Errorf("replacement reported twice: %v", again)
+	}
+}
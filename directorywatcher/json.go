@@ -0,0 +1,105 @@
+package directorywatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The file metadata of an event, flattened into the event's JSON object. It is
+// embedded by pointer in eventJSON, so it is left out entirely when the event
+// doesn't carry a FileInfo.
+type fileInfoJSON struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modtime"`
+	IsDir   bool        `json:"isDir"`
+}
+
+// The wire format of an Event
+type eventJSON struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	*fileInfoJSON
+	Old     *fileInfoJSON `json:"old,omitempty"`
+	OldPath string        `json:"oldPath,omitempty"`
+}
+
+func newFileInfoJSON(info os.FileInfo) *fileInfoJSON {
+	if info == nil {
+		return nil
+	}
+	return &fileInfoJSON{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
+
+// Event implements json.Marshaler. The embedded os.FileInfo is an interface,
+// so instead of marshalling it directly, its metadata is pulled out and
+// flattened next to the type and path. OldInfo, when present, is nested
+// under "old", and OldPath is left out unless it's set.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eventJSON{
+		Type:         e.Type.String(),
+		Path:         e.Path,
+		fileInfoJSON: newFileInfoJSON(e.FileInfo),
+		Old:          newFileInfoJSON(e.OldInfo),
+		OldPath:      e.OldPath,
+	})
+}
+
+// What UnmarshalJSON reads. encoding/json can't allocate an embedded pointer
+// to an unexported struct, so unlike eventJSON the file metadata is embedded
+// by value, and an empty name means there was none.
+type eventJSONIn struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	fileInfoJSON
+	Old     *fileInfoJSON `json:"old,omitempty"`
+	OldPath string        `json:"oldPath,omitempty"`
+}
+
+func (fi *fileInfoJSON) fileInfo(path string) os.FileInfo {
+	mode := fi.Mode
+	if fi.IsDir {
+		mode |= os.ModeDir
+	}
+	return restoredInfo{fi.Name, savedFile{path, fi.Size, mode, fi.ModTime}}
+}
+
+// Event implements json.Unmarshaler, reading what MarshalJSON writes. The
+// FileInfo and OldInfo of the event only have the metadata that was written
+// out; their Sys() is nil.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var in eventJSONIn
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	typ, ok := eventTypeNamed(in.Type)
+	if !ok {
+		return fmt.Errorf("Unknown event type: %q", in.Type)
+	}
+	*e = Event{Type: typ, Path: in.Path, OldPath: in.OldPath}
+	if in.Name != "" {
+		e.FileInfo = in.fileInfoJSON.fileInfo(in.Path)
+	}
+	if in.Old != nil {
+		e.OldInfo = in.Old.fileInfo(in.Path)
+	}
+	return nil
+}
+
+func eventTypeNamed(name string) (eventType, bool) {
+	for typ, n := range eventNames {
+		if n == name {
+			return typ, true
+		}
+	}
+	return 0, false
+}
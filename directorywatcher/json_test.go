@@ -0,0 +1,120 @@
+package directorywatcher
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// A FileInfo that isn't backed by an actual file
+type fakeInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi fakeInfo) Name() string       { return fi.name }
+func (fi fakeInfo) Size() int64        { return fi.size }
+func (fi fakeInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fakeInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fakeInfo) Sys() interface{}   { return nil }
+
+var jsonModTime = time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestEventMarshalJSON(t *testing.T) {
+	info := fakeInfo{"a.txt", 42, 0644, jsonModTime}
+	for _, typ := range []eventType{Added, Changed, Deleted} {
+		b, err := json.Marshal(Event{typ, "dir/a.txt", info, nil, ""})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{
+			"type":    typ.String(),
+			"path":    "dir/a.txt",
+			"name":    "a.txt",
+			"size":    float64(42),
+			"mode":    float64(0644),
+			"modtime": "2014-03-01T12:00:00Z",
+			"isDir":   false,
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %d keys, want %d: %s", typ, len(got), len(want), b)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s: %q = %v, want %v", typ, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestEventMarshalJSONNilFileInfo(t *testing.T) {
+	b, err := json.Marshal(Event{Deleted, "gone.txt", nil, nil, ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"type":"Deleted","path":"gone.txt"}` {
+		t.Errorf("unexpected JSON for event without FileInfo: %s", b)
+	}
+}
+
+func TestEventsAtMarshalJSON(t *testing.T) {
+	evAt := EventsAt{At: jsonModTime, Events: []Event{{Added, "a", nil, nil, ""}}, ScanSeq: 3, TotalFiles: 1}
+	b, err := json.Marshal(evAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"at":"2014-03-01T12:00:00Z","events":[{"type":"Added","path":"a"}],"scanSeq":3,"totalFiles":1}`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestEventUnmarshalJSON(t *testing.T) {
+	info := fakeInfo{"b.txt", 42, 0644, jsonModTime}
+	old := fakeInfo{"a.txt", 7, 0600, jsonModTime.Add(-time.Hour)}
+	in := EventsAt{At: jsonModTime, ScanSeq: 3, TotalFiles: 2, Events: []Event{
+		{Renamed, "dir/b.txt", info, old, "dir/a.txt"},
+		{Deleted, "gone.txt", nil, nil, ""},
+		{Added, "sub", fakeInfo{"sub", 0, os.ModeDir | 0755, jsonModTime}, nil, ""},
+	}}
+	b, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out EventsAt
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.At.Equal(in.At) || out.ScanSeq != 3 || out.TotalFiles != 2 || len(out.Events) != 3 {
+		t.Fatalf("got %+v, want %+v", out, in)
+	}
+	ren := out.Events[0]
+	if ren.Type != Renamed || ren.Path != "dir/b.txt" || ren.OldPath != "dir/a.txt" {
+		t.Errorf("unexpected event %v (from %q)", ren, ren.OldPath)
+	}
+	if ren.Name() != "b.txt" || ren.Size() != 42 || ren.Mode() != 0644 || !ren.ModTime().Equal(jsonModTime) {
+		t.Errorf("unexpected FileInfo %+v", ren.FileInfo)
+	}
+	if ren.OldInfo == nil || ren.OldInfo.Name() != "a.txt" || ren.OldInfo.Size() != 7 {
+		t.Errorf("unexpected OldInfo %+v", ren.OldInfo)
+	}
+	if gone := out.Events[1]; gone.FileInfo != nil || gone.OldInfo != nil {
+		t.Errorf("expected no FileInfo for %v", gone)
+	}
+	if dir := out.Events[2]; !dir.IsDir() {
+		t.Errorf("expected %v to be a directory", dir)
+	}
+
+	var ev Event
+	if err := json.Unmarshal([]byte(`{"type":"Exploded","path":"a"}`), &ev); err == nil {
+		t.Error("expected an error for an unknown event type")
+	}
+}
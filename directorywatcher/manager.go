@@ -0,0 +1,129 @@
+package directorywatcher
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Manager starts and stops a set of named watchers together, and merges
+// their batches into a single channel. The batches can be told apart by their
+// Source.
+type Manager struct {
+	mu       sync.Mutex
+	watchers map[string]*directoryWatcher
+	names    []string // In the order they were added
+	events   chan EventsAt
+	quit     chan struct{}  // Closed by StopAll
+	wg       sync.WaitGroup // The forwarding goroutines
+	started  bool
+	stopped  bool
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		watchers: make(map[string]*directoryWatcher),
+		events:   make(chan EventsAt),
+		quit:     make(chan struct{}),
+	}
+}
+
+// Adds a watcher under name, starting it right away if StartAll was called
+// already. Names must be unique.
+func (m *Manager) Add(name string, dw *directoryWatcher) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return fmt.Errorf("Manager was stopped, cannot add watcher: %s", name)
+	}
+	if _, ok := m.watchers[name]; ok {
+		return fmt.Errorf("Watcher already added: %s", name)
+	}
+	m.watchers[name] = dw
+	m.names = append(m.names, name)
+	if m.started {
+		m.start(dw)
+	}
+	return nil
+}
+
+// The watcher added under name, or nil.
+func (m *Manager) Get(name string) *directoryWatcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.watchers[name]
+}
+
+// The batches of all watchers. The channel is closed by StopAll.
+func (m *Manager) Events() <-chan EventsAt {
+	return m.events
+}
+
+// Starts every watcher, in the order they were added.
+func (m *Manager) StartAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started || m.stopped {
+		return
+	}
+	m.started = true
+	for _, name := range m.names {
+		m.start(m.watchers[name])
+	}
+}
+
+// Starts dw, along with a goroutine forwarding its batches to the merged
+// channel. After StopAll, the goroutine stops forwarding but keeps accepting
+// (and dropping) batches until the watcher is done, so it can't get stuck
+// delivering its last events. Its observer is removed once the goroutine
+// exits, or was closed by UnsubscribeAll, so a watcher started again later
+// doesn't wait on it.
+func (m *Manager) start(dw *directoryWatcher) {
+	c := dw.AddNewObserver()
+	dw.Start()
+	done := dw.exited()
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer dw.RemoveObserver(c)
+		for {
+			select {
+			case evAt, ok := <-c:
+				if !ok {
+					return
+				}
+				select {
+				case m.events <- evAt:
+				case <-m.quit:
+				}
+			case <-m.quit:
+				for {
+					select {
+					case _, ok := <-c:
+						if !ok {
+							return
+						}
+					case <-done:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stops every watcher, waits for them to finish and closes the channel
+// returned by Events(). A Manager can't be started again.
+func (m *Manager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	m.stopped = true
+	for _, name := range m.names {
+		m.watchers[name].Stop()
+	}
+	close(m.quit)
+	m.wg.Wait()
+	close(m.events)
+}
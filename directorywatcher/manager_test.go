@@ -0,0 +1,111 @@
+package directorywatcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	m := NewManager()
+	dirs := map[string]string{"one": t.TempDir(), "two": t.TempDir()}
+	for name, dir := range dirs {
+		dw := newTestWatcher(t, dir)
+		dw.Interval = 10
+		dw.Preload = true
+		if err := m.Add(name, dw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Add("one", newTestWatcher(t, dirs["one"])); err == nil {
+		t.Error("expected an error adding a name twice")
+	}
+	if m.Get("two") == nil || m.Get("three") != nil {
+		t.Error("Get() doesn't find the added watchers")
+	}
+
+	m.StartAll()
+	for name, dir := range dirs {
+		<-m.Get(name).Ready()
+		writeFile(t, filepath.Join(dir, name+".txt"), name, time.Now())
+	}
+	got := make(map[string]bool)
+	timeout := time.After(2 * time.Second)
+	for len(got) < 2 {
+		select {
+		case evAt := <-m.Events():
+			ev := onlyEvent(t, evAt.Events)
+			if ev.Type != Added || filepath.Dir(ev.Path) != evAt.Source {
+				t.Errorf("unexpected event %v from %s", ev, evAt.Source)
+			}
+			got[evAt.Source] = true
+		case <-timeout:
+			t.Fatalf("expected batches from both watchers, got %v", got)
+		}
+	}
+	if !got[dirs["one"]] || !got[dirs["two"]] {
+		t.Errorf("expected batches from %v, got %v", dirs, got)
+	}
+
+	m.StopAll()
+	for name := range dirs {
+		if m.Get(name).Running() {
+			t.Errorf("watcher %s still running after StopAll()", name)
+		}
+	}
+	select {
+	case _, ok := <-m.Events():
+		if ok {
+			t.Error("Events() delivered a batch after StopAll()")
+		}
+	case <-time.After(time.Second):
+		t.Error("Events() not closed by StopAll()")
+	}
+}
+
+func TestManagerClosedObserver(t *testing.T) {
+	m := NewManager()
+	dw := newTestWatcher(t, t.TempDir())
+	dw.Interval = 10
+	dw.Preload = true
+	if err := m.Add("one", dw); err != nil {
+		t.Fatal(err)
+	}
+	m.StartAll()
+	<-dw.Ready()
+	dw.UnsubscribeAll(true)
+	select {
+	case evAt := <-m.Events():
+		t.Errorf("batch forwarded from a closed observer: %+v", evAt)
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.StopAll()
+
+	// Started again on its own, the watcher isn't held up by the manager
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	<-dw.Ready()
+	writeFile(t, filepath.Join(dw.path, "a.txt"), "a", time.Now())
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		t.Fatal("restarted watcher not delivering")
+	}
+}
+
+func TestManagerStopAllRemovesObserver(t *testing.T) {
+	m := NewManager()
+	dw := newTestWatcher(t, t.TempDir())
+	dw.Interval = 10
+	dw.Preload = true
+	if err := m.Add("one", dw); err != nil {
+		t.Fatal(err)
+	}
+	m.StartAll()
+	<-dw.Ready()
+	m.StopAll()
+	if n := dw.ObserverCount(); n != 0 {
+		t.Errorf("manager's observer still attached after StopAll: %d", n)
+	}
+}
@@ -0,0 +1,158 @@
+package directorywatcher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backend selects the mechanism used to discover changes.
+type Backend int
+
+const (
+	Polling Backend = iota // Scan the directory every Interval ms
+	Native                 // Use the OS's file notifications, where supported
+)
+
+// The paths a native backend was told about by the OS. If rescan is set,
+// the OS lost track of things and the whole directory has to be scanned.
+type nativeBatch struct {
+	paths  []string
+	rescan bool
+}
+
+var errNativeUnsupported = errors.New("native backend is not supported on this platform")
+
+// Brings the files map up to date for the paths the native backend reported as
+// touched, and returns the resulting events. Instead of trusting the order of
+// the native events (a create is usually followed by a couple of modifies),
+// each path is stat'ed once, so it ends up as Added, Changed or Deleted just
+// like it would with a scan.
+func (dw *directoryWatcher) reconcile(paths []string) (changed []Event) {
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] || (dw.watchPaths != nil && !dw.watchPaths[path]) {
+			continue
+		}
+		seen[path] = true
+
+		info, err := statEntry(path, dw.FollowSymlinks)
+		if err != nil {
+			changed = append(changed, dw.forget(path)...)
+			continue
+		}
+		if info.IsDir() {
+			if dw.skipped(path, true) {
+				continue
+			}
+			if ev, yes := dw.dirAdded(path, info); yes {
+				changed = append(changed, ev)
+			}
+			continue
+		}
+		if !dw.included(path) || dw.skipped(path, false) {
+			continue
+		}
+		if ev, yes := dw.hasChange(path, info); yes {
+			dw.files[path] = info
+			changed = append(changed, ev)
+		}
+	}
+	dw.stats.tracked(len(dw.files))
+	return dw.pairRenames(changed)
+}
+
+// Removes path, and anything tracked below it, from the files map. This
+// matters for removed or renamed directories, where the native backend won't
+// report the files inside.
+func (dw *directoryWatcher) forget(path string) (deleted []Event) {
+	prefix := path + string(filepath.Separator)
+	for p, info := range dw.files {
+		if p == path || strings.HasPrefix(p, prefix) {
+			deleted = append(deleted, deletedEvent(p, info))
+			delete(dw.files, p)
+		}
+	}
+	return
+}
+
+// The first scan of the native backend, which establishes the files map. It
+// runs before Start returns, so every later change is reported relative to
+// it; the returned batch is for the backend's goroutine to deliver with
+// notifyBaseline.
+func (dw *directoryWatcher) nativeBaseline() EventsAt {
+	return dw.batch(dw.clock.Now(), dw.firstScan())
+}
+
+// The goroutine of a native backend: turns the paths reported by the OS into
+// events, and flushes debounced or held events when they are due. Once touched is
+// closed, it delivers whatever is still pending and closes done. If ctx is
+// done or the watched directory is removed, it stops the backend by closing
+// it.
+func (dw *directoryWatcher) nativeLoop(ctx context.Context, baseline EventsAt, touched <-chan nativeBatch, done chan struct{}, backend io.Closer) {
+	defer close(done)
+	dw.notifyBaseline(baseline)
+	var flush <-chan time.Time
+	gone := false
+	cancelled := ctx.Done()
+	for {
+		var now time.Time
+		select {
+		case b, ok := <-touched:
+			if !ok {
+				dw.flushPending()
+				return
+			}
+			now = dw.clock.Now()
+			var changed []Event
+			if b.rescan {
+				changed = dw.scan2()
+			} else {
+				changed = dw.reconcile(b.paths)
+			}
+			dw.notify(dw.batch(now, dw.outgoing(now, changed)))
+			if !gone && dw.rootGone() {
+				gone = true
+				backend.Close() // Ends touched, once the backend notices
+			}
+		case now = <-flush:
+			dw.notify(dw.flushBatch(now))
+		case <-dw.wake:
+			now = dw.clock.Now()
+			dw.notify(dw.flushBatch(now))
+		case <-cancelled:
+			cancelled = nil
+			backend.Close() // Ends touched, once the backend notices
+			now = dw.clock.Now()
+		}
+		flush = dw.flushTimer(now)
+	}
+}
+
+// What's below dir, if it's a directory, leaving out ignored directories. A
+// backend that watches a whole tree at once reports a directory that was
+// created or moved in, but not the files that were already in it.
+func walkBelow(dir string, skip skipFn, report errorFn) (found []string) {
+	if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+		return nil
+	}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			report(err)
+			return nil
+		}
+		if path == dir {
+			return nil
+		}
+		if info.IsDir() && skip(path, true) {
+			return filepath.SkipDir
+		}
+		found = append(found, path)
+		return nil
+	})
+	return
+}
@@ -0,0 +1,239 @@
+//go:build darwin && cgo
+
+package directorywatcher
+
+/*
+#cgo LDFLAGS: -framework CoreServices
+#include <CoreServices/CoreServices.h>
+#include <dispatch/dispatch.h>
+#include <stdlib.h>
+
+extern void fseventsCallback(ConstFSEventStreamRef stream, uintptr_t info, size_t n, char **paths, FSEventStreamEventFlags *flags, FSEventStreamEventId *ids);
+
+static CFMutableArrayRef newPaths(void) {
+	return CFArrayCreateMutable(NULL, 0, &kCFTypeArrayCallBacks);
+}
+
+static void addPath(CFMutableArrayRef paths, const char *path) {
+	CFStringRef s = CFStringCreateWithCString(NULL, path, kCFStringEncodingUTF8);
+	CFArrayAppendValue(paths, s);
+	CFRelease(s);
+}
+
+static void releasePaths(CFMutableArrayRef paths) {
+	CFRelease(paths);
+}
+
+static dispatch_queue_t newQueue(void) {
+	return dispatch_queue_create("directorywatcher", DISPATCH_QUEUE_SERIAL);
+}
+
+static void releaseQueue(dispatch_queue_t queue) {
+	dispatch_release(queue);
+}
+
+// Creates and starts a stream of file events for paths, which it releases.
+static FSEventStreamRef startStream(CFMutableArrayRef paths, uintptr_t info, double latency, dispatch_queue_t queue) {
+	FSEventStreamContext ctx = {0, (void *)info, NULL, NULL, NULL};
+	FSEventStreamRef stream = FSEventStreamCreate(NULL, (FSEventStreamCallback)fseventsCallback, &ctx, paths,
+		kFSEventStreamEventIdSinceNow, latency,
+		kFSEventStreamCreateFlagFileEvents | kFSEventStreamCreateFlagWatchRoot | kFSEventStreamCreateFlagNoDefer);
+	CFRelease(paths);
+	if (stream == NULL) {
+		return NULL;
+	}
+	FSEventStreamSetDispatchQueue(stream, queue);
+	if (!FSEventStreamStart(stream)) {
+		FSEventStreamInvalidate(stream);
+		FSEventStreamRelease(stream);
+		return NULL;
+	}
+	return stream;
+}
+
+static void noop(void *ctx) {}
+
+// Stops the stream, and waits for a callback in progress to return.
+static void stopStream(FSEventStreamRef stream, dispatch_queue_t queue) {
+	FSEventStreamStop(stream);
+	FSEventStreamInvalidate(stream);
+	FSEventStreamRelease(stream);
+	dispatch_sync_f(queue, NULL, noop);
+	releaseQueue(queue);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+// How long FSEvents collects changes before handing them over, in seconds.
+const fseventsLatency = 0.05
+
+var errStreamFailed = errors.New("FSEventStreamCreate failed")
+
+// The FSEvents backend. FSEvents reports the real paths of the files (with
+// symlinks like /var resolved), so each watched directory is kept with its
+// real path, to translate them back.
+//
+// The callback runs on a dispatch queue of its own, and mustn't block: Close
+// waits for it. So it only queues the paths, for a goroutine to pass on, and
+// walk the directories that were created or moved in.
+type fseventsWatcher struct {
+	stream    C.FSEventStreamRef
+	queue     C.dispatch_queue_t
+	handle    cgo.Handle
+	roots     []fseventsRoot
+	recursive bool
+	skip      skipFn
+	report    errorFn
+
+	mu      sync.Mutex
+	batches []fseventsBatch // Waiting to be passed on
+	wake    chan struct{}
+	quit    chan struct{}
+	once    sync.Once
+}
+
+// A batch as the callback queues it, with the directories whose contents are
+// still to be found.
+type fseventsBatch struct {
+	nativeBatch
+	dirs []string
+}
+
+type fseventsRoot struct {
+	path string // As watched
+	real string // With symlinks resolved, as FSEvents reports it
+}
+
+func (dw *directoryWatcher) startNative(ctx context.Context) (io.Closer, error) {
+	w := &fseventsWatcher{
+		recursive: dw.Recursive,
+		skip:      dw.skipped,
+		report:    dw.reportError,
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+	}
+	paths := C.newPaths()
+	for _, root := range dw.roots {
+		real, err := filepath.EvalSymlinks(root.abs)
+		if err != nil {
+			C.releasePaths(paths)
+			return nil, err
+		}
+		w.roots = append(w.roots, fseventsRoot{root.path, real})
+		cpath := C.CString(real)
+		C.addPath(paths, cpath)
+		C.free(unsafe.Pointer(cpath))
+	}
+	w.handle = cgo.NewHandle(w)
+	w.queue = C.newQueue()
+	// The stream starts before the first scan, so nothing slips in between.
+	w.stream = C.startStream(paths, C.uintptr_t(w.handle), fseventsLatency, w.queue)
+	if w.stream == nil {
+		C.releaseQueue(w.queue)
+		w.handle.Delete()
+		return nil, errStreamFailed
+	}
+	touched := make(chan nativeBatch)
+	go dw.nativeLoop(ctx, dw.nativeBaseline(), touched, dw.done, w)
+	go w.forward(touched)
+	return w, nil
+}
+
+//export fseventsCallback
+func fseventsCallback(stream C.ConstFSEventStreamRef, info C.uintptr_t, n C.size_t, paths **C.char, flags *C.FSEventStreamEventFlags, ids *C.FSEventStreamEventId) {
+	w := cgo.Handle(info).Value().(*fseventsWatcher)
+	var b fseventsBatch
+	cpaths := unsafe.Slice(paths, int(n))
+	cflags := unsafe.Slice(flags, int(n))
+	for i := range cpaths {
+		flag := cflags[i]
+		if flag&(C.kFSEventStreamEventFlagMustScanSubDirs|C.kFSEventStreamEventFlagUserDropped|C.kFSEventStreamEventFlagKernelDropped|C.kFSEventStreamEventFlagRootChanged) != 0 {
+			b.rescan = true
+			continue
+		}
+		path, ok := w.translate(C.GoString(cpaths[i]))
+		if !ok {
+			continue
+		}
+		b.paths = append(b.paths, path)
+		created := flag&(C.kFSEventStreamEventFlagItemCreated|C.kFSEventStreamEventFlagItemRenamed) != 0
+		if w.recursive && created && flag&C.kFSEventStreamEventFlagItemIsDir != 0 {
+			b.dirs = append(b.dirs, path)
+		}
+	}
+	if len(b.paths) == 0 && !b.rescan {
+		return
+	}
+	w.mu.Lock()
+	w.batches = append(w.batches, b)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Turns a path reported by FSEvents into one below a watched directory, as it
+// was given. Without Recursive, only the directories themselves and what's
+// directly in them is of interest.
+func (w *fseventsWatcher) translate(real string) (string, bool) {
+	for _, root := range w.roots {
+		if !within(root.real, real) {
+			continue
+		}
+		rel, err := filepath.Rel(root.real, real)
+		if err != nil || (!w.recursive && filepath.Dir(rel) != ".") {
+			return "", false
+		}
+		return filepath.Join(root.path, rel), true
+	}
+	return "", false
+}
+
+// Passes the queued paths on to touched, along with what's below the
+// directories that came in, until Close is called.
+func (w *fseventsWatcher) forward(touched chan<- nativeBatch) {
+	defer close(touched)
+	for {
+		select {
+		case <-w.wake:
+		case <-w.quit:
+			return
+		}
+		w.mu.Lock()
+		batches := w.batches
+		w.batches = nil
+		w.mu.Unlock()
+		for _, b := range batches {
+			for _, dir := range b.dirs {
+				b.paths = append(b.paths, walkBelow(dir, w.skip, w.report)...)
+			}
+			select {
+			case touched <- b.nativeBatch:
+			case <-w.quit:
+				return
+			}
+		}
+	}
+}
+
+// Close implements io.Closer. Once it returns, the callback isn't called
+// anymore; the forwarding goroutine exits on its own.
+func (w *fseventsWatcher) Close() error {
+	w.once.Do(func() {
+		C.stopStream(w.stream, w.queue)
+		w.handle.Delete()
+		close(w.quit)
+	})
+	return nil
+}
@@ -0,0 +1,147 @@
+//go:build linux
+
+package directorywatcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_CLOSE_WRITE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// The inotify backend. The inotify descriptor is wrapped in an os.File, so
+// closing it unblocks the reading goroutine.
+type inotifyWatcher struct {
+	fd     int
+	f      *os.File
+	dirs   map[int32]string // watch descriptor -> watched directory
+	skip   skipFn
+	report errorFn
+}
+
+func (dw *directoryWatcher) startNative(ctx context.Context) (io.Closer, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		fd:     fd,
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+		skip:   dw.skipped,
+		report: dw.reportError,
+	}
+	// Watches go in before the first scan, so nothing slips in between. Only
+	// failing to watch the directories themselves is fatal.
+	for _, root := range dw.roots {
+		if err := w.add(root.path); err != nil {
+			w.f.Close()
+			return nil, err
+		}
+		if dw.Recursive {
+			w.addDir(root.path)
+		}
+	}
+	touched := make(chan nativeBatch)
+	go dw.nativeLoop(ctx, dw.nativeBaseline(), touched, dw.done, w.f)
+	go w.read(dw.Recursive, touched)
+	return w.f, nil
+}
+
+// Adds a watch on dir and every directory below it (watching a directory
+// twice is harmless). Returns the paths of the files and directories found
+// while descending, which may have been created before their directory was
+// being watched.
+// Directories that can't be read or watched are reported and skipped, ignored
+// ones are just skipped.
+func (w *inotifyWatcher) addDir(dir string) (found []string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			w.report(err)
+			return nil
+		}
+		if !info.IsDir() {
+			found = append(found, path)
+			return nil
+		}
+		if w.skip(path, true) {
+			return filepath.SkipDir
+		}
+		if err := w.add(path); err != nil {
+			w.report(&os.PathError{Op: "inotify_add_watch", Path: path, Err: err})
+			return filepath.SkipDir
+		}
+		if path != dir {
+			found = append(found, path)
+		}
+		return nil
+	})
+	return
+}
+
+func (w *inotifyWatcher) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.dirs[int32(wd)] = dir
+	return nil
+}
+
+// Reads inotify events until the descriptor is closed by Stop().
+func (w *inotifyWatcher) read(recursive bool, touched chan<- nativeBatch) {
+	defer close(touched)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		paths, overflow := w.parse(buf[:n], recursive)
+		touched <- nativeBatch{paths, overflow}
+	}
+}
+
+// Translates a buffer of inotify events into the paths they touched. New
+// directories in a recursive watch are watched as well, with the files already
+// in them reported as touched. If the kernel's queue overflowed, events are
+// lost and the caller has to fall back to a full scan.
+func (w *inotifyWatcher) parse(buf []byte, recursive bool) (paths []string, overflow bool) {
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		nameStart := off + syscall.SizeofInotifyEvent
+		name := strings.TrimRight(string(buf[nameStart:nameStart+int(raw.Len)]), "\x00")
+		off = nameStart + int(raw.Len)
+
+		if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			overflow = true
+			continue
+		}
+		dir, ok := w.dirs[raw.Wd]
+		if !ok {
+			continue
+		}
+		if raw.Mask&(syscall.IN_DELETE_SELF|syscall.IN_IGNORED) != 0 {
+			if raw.Mask&syscall.IN_DELETE_SELF != 0 {
+				paths = append(paths, dir) // Forget whatever is left below it
+			}
+			delete(w.dirs, raw.Wd)
+			continue
+		}
+		path := filepath.Join(dir, name)
+		paths = append(paths, path)
+
+		created := raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0
+		if recursive && created && raw.Mask&syscall.IN_ISDIR != 0 {
+			paths = append(paths, w.addDir(path)...)
+		}
+	}
+	return
+}
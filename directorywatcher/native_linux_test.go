@@ -0,0 +1,266 @@
+//go:build linux
+
+package directorywatcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNativeBackend(t *testing.T) {
+	for _, recursive := range []bool{false, true} {
+		dir := t.TempDir()
+		dw := newTestWatcher(t, dir)
+		dw.Backend = Native
+		dw.Interval = 3600 * 1000
+		dw.Recursive = recursive
+		dw.Preload = true
+		c := dw.AddNewObserver()
+		dw.Start()
+		if dw.native == nil {
+			t.Fatal("native backend did not start")
+		}
+
+		path := filepath.Join(dir, "a.txt")
+		writeFile(t, path, "hello", time.Now().Add(-time.Minute))
+		waitForEvent(t, c, Added, path)
+
+		writeFile(t, path, "hello, again", time.Now())
+		if ev := waitForEvent(t, c, Changed, path); ev.OldInfo == nil || ev.OldInfo.Size() != 5 {
+			t.Errorf("expected the Changed event to carry the old size, got OldInfo %v", ev.OldInfo)
+		}
+
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		waitForEvent(t, c, Deleted, path)
+
+		dw.Stop()
+		if dw.Running() {
+			t.Error("watcher still running after Stop()")
+		}
+	}
+}
+
+func TestNativeBackendRecursiveNewDirectory(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.Recursive = true
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(sub, "b.txt")
+	writeFile(t, path, "b", time.Now())
+	waitForEvent(t, c, Added, path)
+
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, c, Deleted, path)
+}
+
+func TestNativeBackendPattern(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.Pattern = "*.go"
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	writeFile(t, filepath.Join(dir, "skip.txt"), "x", time.Now())
+	path := filepath.Join(dir, "main.go")
+	writeFile(t, path, "package main", time.Now())
+	if ev := waitForEvent(t, c, Added, path); ev.Name() != "main.go" {
+		t.Errorf("unexpected event %v", ev)
+	}
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case evAt := <-c:
+			for _, ev := range evAt.Events {
+				if ev.Path != path {
+					t.Errorf("unexpected event for a file not matching Pattern: %v", ev)
+				}
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// The native backend doesn't scan periodically, so debounced events have to
+// be flushed by the timer.
+func TestNativeBackendDebounce(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.Debounce = 50 * time.Millisecond
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	path := filepath.Join(dir, "a.txt")
+	then := time.Now().Add(time.Hour) // Later writes must look newer
+	for i := 1; i <= 3; i++ {
+		writeFile(t, path, string(make([]byte, i)), then.Add(time.Duration(i)*time.Minute))
+	}
+	ev := onlyEvent(t, receive(t, c).Events)
+	if ev.Type != Added || ev.Size() != 3 {
+		t.Errorf("expected a single Added event with the final size, got %v (size %d)", ev, ev.Size())
+	}
+	select {
+	case evAt := <-c:
+		t.Errorf("unexpected second batch: %v", evAt.Events)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestNativeBackendRootRemoved(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a", time.Now().Add(-time.Hour))
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, c, Deleted, path)
+	rootRemoved(t, dw)
+	select {
+	case <-dw.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("native backend still running after its directory was removed")
+	}
+}
+
+func TestWithBackend(t *testing.T) {
+	dir := t.TempDir()
+	dw, err := New(dir, WithBackend(Native))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw.Interval = 3600 * 1000
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	if dw.native == nil {
+		t.Fatal("native backend did not start")
+	}
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a", time.Now())
+	waitForEvent(t, c, Added, path)
+
+	if _, err := New(dir, WithBackend(Backend(42))); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
+
+func TestNativeBackendUnreadableSubdirectory(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	dir := t.TempDir()
+	locked := filepath.Join(dir, "locked")
+	if err := os.Mkdir(locked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.Recursive = true
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	if dw.native == nil {
+		t.Fatal("native backend did not start")
+	}
+	select {
+	case err := <-dw.Errors():
+		var serr *ScanError
+		if !errors.As(err, &serr) || serr.Path != locked {
+			t.Errorf("expected an error for %s, got %v", locked, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("unreadable directory not reported")
+	}
+
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a", time.Now())
+	waitForEvent(t, c, Added, path)
+}
+
+func TestNativeBackendReportDirectories(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.Recursive = true
+	dw.ReportDirectories = true
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	sub := filepath.Join(dir, "sub")
+	deep := filepath.Join(sub, "deep")
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if ev := waitForEvent(t, c, Added, deep); !ev.IsDir() {
+		t.Errorf("expected a directory event, got %v", ev)
+	}
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, c, Deleted, sub)
+}
+
+func TestNativeBackendRename(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	writeFile(t, a, "a", time.Now().Add(-time.Hour))
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.DetectRenames = true
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	if err := os.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if ev := waitForEvent(t, c, Renamed, b); ev.OldPath != a {
+		t.Errorf("expected a rename from %s, got one from %q", a, ev.OldPath)
+	}
+}
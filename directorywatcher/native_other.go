@@ -0,0 +1,13 @@
+//go:build !linux && !windows && !(darwin && cgo)
+
+package directorywatcher
+
+import (
+	"context"
+	"io"
+)
+
+// No native backend here, so Start falls back to polling.
+func (dw *directoryWatcher) startNative(ctx context.Context) (io.Closer, error) {
+	return nil, errNativeUnsupported
+}
@@ -0,0 +1,26 @@
+package directorywatcher
+
+import (
+	"testing"
+	"time"
+)
+
+// Reads batches from c until one contains an event of the given type for
+// path. The watcher polls once an hour, so anything arriving within the
+// timeout came from the native backend.
+func waitForEvent(t *testing.T, c Observer, typ eventType, path string) Event {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case evAt := <-c:
+			for _, ev := range evAt.Events {
+				if ev.Type == typ && ev.Path == path {
+					return ev
+				}
+			}
+		case <-timeout:
+			t.Fatalf("no %s event for %s", typ, path)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+//go:build windows
+
+package directorywatcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const rdcMask = syscall.FILE_NOTIFY_CHANGE_FILE_NAME | syscall.FILE_NOTIFY_CHANGE_DIR_NAME |
+	syscall.FILE_NOTIFY_CHANGE_ATTRIBUTES | syscall.FILE_NOTIFY_CHANGE_SIZE |
+	syscall.FILE_NOTIFY_CHANGE_LAST_WRITE | syscall.FILE_NOTIFY_CHANGE_CREATION
+
+// Returned instead of the changes when they didn't fit in the buffer.
+const errNotifyEnumDir syscall.Errno = 1022
+
+// The ReadDirectoryChangesW backend. Every watched directory has a handle on
+// one I/O completion port, with a read pending on it. Close posts a completion
+// without a key, which tells the reading goroutine to clean up and exit.
+type rdcWatcher struct {
+	port      syscall.Handle
+	dirs      []*rdcDir // By completion key, minus one
+	pending   int       // Reads the kernel hasn't completed yet
+	recursive bool
+	skip      skipFn
+	report    errorFn
+	once      sync.Once
+}
+
+type rdcDir struct {
+	path string
+	h    syscall.Handle
+	ov   syscall.Overlapped
+	buf  []byte
+}
+
+func (dw *directoryWatcher) startNative(ctx context.Context) (io.Closer, error) {
+	port, err := syscall.CreateIoCompletionPort(syscall.InvalidHandle, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	w := &rdcWatcher{
+		port:      port,
+		recursive: dw.Recursive,
+		skip:      dw.skipped,
+		report:    dw.reportError,
+	}
+	// Watches go in before the first scan, so nothing slips in between.
+	for _, root := range dw.roots {
+		if err := w.add(root.path); err != nil {
+			w.cleanup()
+			return nil, err
+		}
+	}
+	touched := make(chan nativeBatch)
+	go dw.nativeLoop(ctx, dw.nativeBaseline(), touched, dw.done, w)
+	go w.read(touched)
+	return w, nil
+}
+
+func (w *rdcWatcher) add(dir string) error {
+	name, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return err
+	}
+	h, err := syscall.CreateFile(name, syscall.FILE_LIST_DIRECTORY,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS|syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return &os.PathError{Op: "CreateFile", Path: dir, Err: err}
+	}
+	d := &rdcDir{path: dir, h: h, buf: make([]byte, 64*1024)}
+	w.dirs = append(w.dirs, d)
+	if _, err := syscall.CreateIoCompletionPort(h, w.port, uint32(len(w.dirs)), 0); err != nil {
+		return err
+	}
+	return w.watch(d)
+}
+
+// Starts the next read of d's changes.
+func (w *rdcWatcher) watch(d *rdcDir) error {
+	d.ov = syscall.Overlapped{}
+	err := syscall.ReadDirectoryChanges(d.h, &d.buf[0], uint32(len(d.buf)), w.recursive, rdcMask, nil, &d.ov, 0)
+	if err != nil {
+		return &os.PathError{Op: "ReadDirectoryChanges", Path: d.path, Err: err}
+	}
+	w.pending++
+	return nil
+}
+
+// Close implements io.Closer. It doesn't wait for the reading goroutine.
+func (w *rdcWatcher) Close() error {
+	w.once.Do(func() {
+		syscall.PostQueuedCompletionStatus(w.port, 0, 0, nil)
+	})
+	return nil
+}
+
+// Reads completed changes until Close is called. A directory whose changes
+// can't be read anymore (it was removed, most likely) is reported as touched,
+// so whatever was below it is forgotten.
+func (w *rdcWatcher) read(touched chan<- nativeBatch) {
+	defer close(touched)
+	defer w.cleanup()
+	for {
+		var n, key uint32
+		var ov *syscall.Overlapped
+		err := syscall.GetQueuedCompletionStatus(w.port, &n, &key, &ov, syscall.INFINITE)
+		if ov == nil {
+			return // Closed, or the port itself failed
+		}
+		w.pending--
+		d := w.dirs[key-1]
+		switch {
+		case err == errNotifyEnumDir || (err == nil && n == 0):
+			touched <- nativeBatch{rescan: true}
+		case err != nil:
+			w.drop(d, touched)
+			continue
+		default:
+			touched <- nativeBatch{paths: w.parse(d, d.buf[:n])}
+		}
+		if err := w.watch(d); err != nil {
+			w.drop(d, touched)
+		}
+	}
+}
+
+func (w *rdcWatcher) drop(d *rdcDir, touched chan<- nativeBatch) {
+	syscall.CloseHandle(d.h)
+	d.h = syscall.InvalidHandle
+	touched <- nativeBatch{paths: []string{d.path}}
+}
+
+// Translates a buffer of FILE_NOTIFY_INFORMATION records into the paths they
+// touched. The files in directories that were created or moved in are
+// reported as touched too, since the watch only sees what happens after.
+func (w *rdcWatcher) parse(d *rdcDir, buf []byte) (paths []string) {
+	for off := 0; off < len(buf); {
+		raw := (*syscall.FileNotifyInformation)(unsafe.Pointer(&buf[off]))
+		name := syscall.UTF16ToString(unsafe.Slice(&raw.FileName, raw.FileNameLength/2))
+		path := filepath.Join(d.path, name)
+		paths = append(paths, path)
+
+		created := raw.Action == syscall.FILE_ACTION_ADDED || raw.Action == syscall.FILE_ACTION_RENAMED_NEW_NAME
+		if w.recursive && created {
+			paths = append(paths, walkBelow(path, w.skip, w.report)...)
+		}
+		if raw.NextEntryOffset == 0 {
+			break
+		}
+		off += int(raw.NextEntryOffset)
+	}
+	return
+}
+
+// Cancels the pending reads and waits for the kernel to let go of their
+// buffers, before closing the handles and the port.
+func (w *rdcWatcher) cleanup() {
+	for _, d := range w.dirs {
+		if d.h != syscall.InvalidHandle {
+			syscall.CancelIoEx(d.h, nil)
+		}
+	}
+	for w.pending > 0 {
+		var n, key uint32
+		var ov *syscall.Overlapped
+		err := syscall.GetQueuedCompletionStatus(w.port, &n, &key, &ov, syscall.INFINITE)
+		if ov == nil {
+			if err != nil {
+				break // Nothing more will come
+			}
+			continue // Not a read
+		}
+		w.pending--
+	}
+	for _, d := range w.dirs {
+		if d.h != syscall.InvalidHandle {
+			syscall.CloseHandle(d.h)
+		}
+	}
+	syscall.CloseHandle(w.port)
+}
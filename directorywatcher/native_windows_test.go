@@ -0,0 +1,74 @@
+//go:build windows
+
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNativeBackend(t *testing.T) {
+	for _, recursive := range []bool{false, true} {
+		dir := t.TempDir()
+		dw := newTestWatcher(t, dir)
+		dw.Backend = Native
+		dw.Interval = 3600 * 1000
+		dw.Recursive = recursive
+		dw.Preload = true
+		c := dw.AddNewObserver()
+		dw.Start()
+		if dw.native == nil {
+			t.Fatal("native backend did not start")
+		}
+
+		path := filepath.Join(dir, "a.txt")
+		writeFile(t, path, "hello", time.Now().Add(-time.Minute))
+		waitForEvent(t, c, Added, path)
+
+		writeFile(t, path, "hello, again", time.Now())
+		waitForEvent(t, c, Changed, path)
+
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		waitForEvent(t, c, Deleted, path)
+
+		dw.StopAndWait()
+		if dw.Running() {
+			t.Error("watcher still running after Stop()")
+		}
+	}
+}
+
+// A directory moved in brings its files along, without events of their own.
+func TestNativeBackendRecursiveMovedDirectory(t *testing.T) {
+	dir, elsewhere := t.TempDir(), t.TempDir()
+	moved := filepath.Join(elsewhere, "sub")
+	if err := os.Mkdir(moved, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(moved, "b.txt"), "b", time.Now())
+
+	dw := newTestWatcher(t, dir)
+	dw.Backend = Native
+	dw.Interval = 3600 * 1000
+	dw.Recursive = true
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Rename(moved, sub); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(sub, "b.txt")
+	waitForEvent(t, c, Added, path)
+
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+	waitForEvent(t, c, Deleted, path)
+}
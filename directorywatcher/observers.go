@@ -0,0 +1,194 @@
+package directorywatcher
+
+import (
+	"sync"
+	"time"
+)
+
+// DeliveryPolicy is what the watcher does when an observer isn't ready to
+// receive a batch.
+type DeliveryPolicy int
+
+const (
+	Block      DeliveryPolicy = iota // Wait for it (up to DeliveryTimeout, if set)
+	DropNewest                       // Drop the batch that doesn't fit
+	DropOldest                       // Make room by dropping the oldest batch in the channel
+)
+
+// An attached observer, along with what it was attached with
+type subscriber struct {
+	ch       Observer
+	priority int
+	accept   func(Event) bool // The events to deliver, nil for all
+	policy   DeliveryPolicy
+
+	mu      sync.Mutex    // Held while delivering, so ch isn't closed under our feet
+	quit    chan struct{} // Closed on removal, to abandon a pending delivery
+	once    sync.Once
+	removed bool
+}
+
+func newSubscriber(ch Observer, priority int) *subscriber {
+	return &subscriber{ch: ch, priority: priority, quit: make(chan struct{})}
+}
+
+// The batch as the observer gets to see it: only the events it asked for, and
+// not at all (ok is false) if none of them are left. Batches that were empty
+// to begin with (heartbeats, say) are sent as is.
+func (sub *subscriber) filter(evAt EventsAt) (_ EventsAt, ok bool) {
+	if sub.accept == nil || len(evAt.Events) == 0 {
+		return evAt, true
+	}
+	var events []Event
+	for _, ev := range evAt.Events {
+		if sub.accept(ev) {
+			events = append(events, ev)
+		}
+	}
+	evAt.Events = events
+	return evAt, len(events) > 0
+}
+
+// Sends a batch to the observer according to its policy, giving up after
+// timeout (if non-zero) or when the observer is removed. Returns false if a
+// batch was dropped.
+func (sub *subscriber) deliver(evAt EventsAt, timeout time.Duration) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.removed {
+		return true
+	}
+	switch sub.policy {
+	case DropNewest:
+		select {
+		case sub.ch <- evAt:
+			return true
+		default:
+			return false
+		}
+	case DropOldest:
+		dropped := false
+		for {
+			select {
+			case sub.ch <- evAt:
+				return !dropped
+			default:
+			}
+			if cap(sub.ch) == 0 {
+				return false // Nowhere to make room in
+			}
+			select {
+			case <-sub.ch:
+				dropped = true
+			default: // A receiver got there first
+			}
+		}
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case sub.ch <- evAt:
+	case <-sub.quit:
+	case <-expired:
+		return false
+	}
+	return true
+}
+
+// Stops delivery to the observer, waiting for a delivery in progress to be
+// abandoned.
+func (sub *subscriber) remove() {
+	sub.once.Do(func() { close(sub.quit) })
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.removed = true
+}
+
+// Adds an observer that is delivered to according to policy, instead of
+// blocking the watcher until it's ready. Dropped batches are counted by
+// Dropped(). The policies are meant for buffered channels, see
+// AddNewBufferedObserver; an unbuffered one only gets the batches it is
+// waiting for.
+func (dw *directoryWatcher) AddObserverPolicy(obs Observer, policy DeliveryPolicy) {
+	sub := newSubscriber(obs, 0)
+	sub.policy = policy
+	dw.subscribe(sub)
+}
+
+// Adds and returns an observer with a buffer of size batches, delivered to
+// according to policy.
+func (dw *directoryWatcher) AddNewBufferedObserver(size int, policy DeliveryPolicy) Observer {
+	o := make(Observer, size)
+	dw.AddObserverPolicy(o, policy)
+	return o
+}
+
+// Adds and returns an observer that only receives the events accept returns
+// true for, e.g.
+//
+//	dw.AddFilteredObserver(func(e Event) bool {
+//		return e.Type == Changed && strings.HasSuffix(e.Path, ".go")
+//	})
+//
+// Batches are filtered as for AddObserverFiltered. accept is called from the
+// watcher's goroutine, so it should be quick.
+func (dw *directoryWatcher) AddFilteredObserver(accept func(Event) bool) Observer {
+	o := NewObserver()
+	sub := newSubscriber(o, 0)
+	sub.accept = accept
+	dw.subscribe(sub)
+	return o
+}
+
+// Detaches an observer (every time it was added). Once RemoveObserver returns,
+// nothing more is sent to it; a batch it hadn't accepted yet is abandoned.
+// Returns whether the observer was attached.
+func (dw *directoryWatcher) RemoveObserver(obs Observer) bool {
+	dw.obsMu.Lock()
+	var removed []*subscriber
+	observers := make([]*subscriber, 0, len(dw.observers))
+	for _, sub := range dw.observers {
+		if sub.ch == obs {
+			removed = append(removed, sub)
+		} else {
+			observers = append(observers, sub)
+		}
+	}
+	dw.observers = observers
+	dw.obsMu.Unlock()
+
+	for _, sub := range removed {
+		sub.remove()
+	}
+	return len(removed) > 0
+}
+
+// Detaches every observer, as RemoveObserver does. With closeChannels, their
+// channels are closed afterwards, so observers ranging over them finish; don't
+// use that for channels that are shared with other watchers.
+func (dw *directoryWatcher) UnsubscribeAll(closeChannels bool) {
+	dw.obsMu.Lock()
+	removed := dw.observers
+	dw.observers = []*subscriber{}
+	dw.obsMu.Unlock()
+
+	// A channel can be attached more than once, so it's only closed once
+	// none of its subscribers can deliver to it anymore
+	for _, sub := range removed {
+		sub.remove()
+	}
+	if !closeChannels {
+		return
+	}
+	closed := make(map[Observer]bool)
+	for _, sub := range removed {
+		if !closed[sub.ch] {
+			close(sub.ch)
+			closed[sub.ch] = true
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package directorywatcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveObserver(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", then)
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	stuck := dw.AddNewObserver() // Never read
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+
+	// Delivery of the baseline is stuck on the first observer, or would
+	// be, until it's removed
+	if !dw.RemoveObserver(stuck) {
+		t.Fatal("RemoveObserver() didn't find the observer")
+	}
+	if dw.RemoveObserver(stuck) {
+		t.Error("RemoveObserver() found an observer that was removed already")
+	}
+	receive(t, c)
+
+	writeFile(t, filepath.Join(dir, "a.txt"), "aa", then.Add(time.Minute))
+	ft.tickUntil(t, fc.now, c)
+	select {
+	case evAt := <-stuck:
+		t.Errorf("removed observer got %v", evAt)
+	default:
+	}
+	if n := dw.ObserverCount(); n != 1 {
+		t.Errorf("ObserverCount() = %d after removing one of two", n)
+	}
+}
+
+func TestUnsubscribeAll(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", time.Now())
+
+	dw := newTestWatcher(t, dir)
+	dw.clock = newFakeClock()
+	c := dw.AddNewObserver()
+	dw.AddObserverFiltered(c, Deleted) // The same channel twice
+	done := make(chan int)
+	go func() {
+		n := 0
+		for range c {
+			n++
+		}
+		done <- n
+	}()
+	dw.Start()
+	defer dw.Stop()
+	<-dw.Ready()
+
+	dw.UnsubscribeAll(true)
+	select {
+	case n := <-done:
+		if n > 1 {
+			t.Errorf("expected at most the baseline before unsubscribing, got %d batches", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("observer channel not closed by UnsubscribeAll(true)")
+	}
+	if n := dw.ObserverCount(); n != 0 {
+		t.Errorf("ObserverCount() = %d after UnsubscribeAll()", n)
+	}
+}
+
+// Closing a channel attached twice mustn't leave its other subscriber
+// delivering to it.
+func TestUnsubscribeAllSharedChannel(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	c := NewObserver()
+	dw.AddObserver(c)
+	dw.AddObserverPriority(c, 1)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		dw.send(EventsAt{ScanSeq: 1, Events: []Event{{Added, "a", nil, nil, ""}}})
+	}()
+	time.Sleep(50 * time.Millisecond) // Blocked delivering, as nobody reads c
+	dw.UnsubscribeAll(true)
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("delivery not abandoned by UnsubscribeAll")
+	}
+	if _, ok := <-c; ok {
+		t.Error("channel not closed by UnsubscribeAll(true)")
+	}
+}
+
+func TestDeliveryPolicies(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	newest := dw.AddNewBufferedObserver(2, DropNewest)
+	oldest := dw.AddNewBufferedObserver(2, DropOldest)
+	unbuffered := NewObserver()
+	dw.AddObserverPolicy(unbuffered, DropOldest)
+	blocking := dw.AddNewBufferedObserver(4, Block)
+
+	// Nobody is reading, yet none of this blocks
+	for seq := uint64(1); seq <= 4; seq++ {
+		dw.send(EventsAt{ScanSeq: seq})
+	}
+	seqs := func(c Observer) (got []uint64) {
+		for len(c) > 0 {
+			got = append(got, (<-c).ScanSeq)
+		}
+		return
+	}
+	if got := seqs(newest); fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("DropNewest kept %v, want [1 2]", got)
+	}
+	if got := seqs(oldest); fmt.Sprint(got) != "[3 4]" {
+		t.Errorf("DropOldest kept %v, want [3 4]", got)
+	}
+	if got := seqs(blocking); fmt.Sprint(got) != "[1 2 3 4]" {
+		t.Errorf("Block kept %v, want all four", got)
+	}
+	// Two batches each for the buffered observers, four for the unbuffered one
+	if n := dw.Dropped(); n != 8 {
+		t.Errorf("Dropped() = %d, want 8", n)
+	}
+}
+
+func TestAddFilteredObserver(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	goChanges := dw.AddFilteredObserver(func(e Event) bool {
+		return e.Type == Changed && strings.HasSuffix(e.Path, ".go")
+	})
+	go func() {
+		dw.send(EventsAt{ScanSeq: 1, Events: []Event{{Type: Changed, Path: "a.txt"}}})
+		dw.send(EventsAt{ScanSeq: 2, Events: []Event{
+			{Type: Added, Path: "b.go"},
+			{Type: Changed, Path: "c.go"},
+			{Type: Changed, Path: "d.txt"},
+		}})
+	}()
+
+	got := receive(t, goChanges)
+	if got.ScanSeq != 2 {
+		t.Errorf("expected the batch without matches to be skipped, got batch %d", got.ScanSeq)
+	}
+	if ev := onlyEvent(t, got.Events); ev.Path != "c.go" {
+		t.Errorf("expected only the change to c.go, got %v", ev)
+	}
+}
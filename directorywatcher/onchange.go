@@ -0,0 +1,35 @@
+package directorywatcher
+
+import "time"
+
+// Calls fn with the events on files in the current directory (recursively)
+// matching any of patterns, once they've been quiet for debounce. Patterns are
+// as for the Patterns field: those with a slash, like "cmd/**/*.go", match the
+// path relative to the current directory, others the file name; without any,
+// every file matches. The files already there when OnChange is called aren't
+// reported. opts are applied after these settings, e.g. WithIgnore(".git/",
+// "node_modules/") keeps those directories from being scanned at all.
+//
+// fn is called from a single goroutine, and changes made while it runs (say,
+// saving a file during a build) are passed to the next call, see
+// OnEventsCoalesced. Call stop to stop watching; fn isn't called after stop
+// returns.
+func OnChange(patterns []string, debounce time.Duration, fn func([]Event), opts ...Option) (stop func(), err error) {
+	opts = append([]Option{
+		WithPatterns(patterns...),
+		WithRecursive(true),
+		WithPreload(),
+		WithDebounce(debounce),
+		WithBackend(Native),
+	}, opts...)
+	dw, err := New(".", opts...)
+	if err != nil {
+		return nil, err
+	}
+	stopFn := dw.OnEventsCoalesced(fn)
+	dw.Start()
+	return func() {
+		dw.Stop()
+		stopFn()
+	}, nil
+}
@@ -0,0 +1,134 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOnChange(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "cmd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	then := time.Now().Add(time.Hour) // Later writes must look newer
+	writeFile(t, filepath.Join(dir, "existing.go"), "package main", then)
+	t.Chdir(dir)
+
+	calls := make(chan []Event, 10)
+	stop, err := OnChange([]string{"*.go", filepath.Join("cmd", "*.txt")}, 50*time.Millisecond, func(events []Event) {
+		calls <- events
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	writeFile(t, "notes.txt", "not matched", then)
+	writeFile(t, filepath.Join("cmd", "usage.txt"), "matched by path", then)
+	for i := 1; i <= 3; i++ {
+		writeFile(t, "main.go", string(make([]byte, i)), then.Add(time.Duration(i)*time.Minute))
+	}
+
+	got := make(map[string]Event)
+	timeout := time.After(5 * time.Second)
+	for len(got) < 2 {
+		select {
+		case events := <-calls:
+			for _, ev := range events {
+				if _, dup := got[ev.Path]; dup {
+					t.Errorf("burst not debounced, %s reported again: %v", ev.Path, ev)
+				}
+				got[ev.Path] = ev
+			}
+		case <-timeout:
+			t.Fatalf("expected events for main.go and cmd/usage.txt, got %v", got)
+		}
+	}
+	if ev, ok := got["main.go"]; !ok || ev.Type != Added || ev.Size() != 3 {
+		t.Errorf("expected a single Added event for main.go with its final size, got %v", got)
+	}
+	if _, ok := got[filepath.Join("cmd", "usage.txt")]; !ok {
+		t.Errorf("expected an event for cmd/usage.txt, got %v", got)
+	}
+	select {
+	case events := <-calls:
+		t.Errorf("unexpected call with %v", events)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	if _, err := OnChange([]string{"["}, 0, func([]Event) {}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestOnChangeIgnoreAndDeepPatterns(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"cmd/x/y", "node_modules/cmd"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+
+	calls := make(chan []Event, 10)
+	stop, err := OnChange([]string{"cmd/**/*.go", "**/cmd/*.go"}, 50*time.Millisecond, func(events []Event) {
+		calls <- events
+	}, WithIgnore("node_modules/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	then := time.Now().Add(time.Hour)
+	writeFile(t, filepath.Join("node_modules", "cmd", "a.go"), "ignored", then)
+	writeFile(t, filepath.Join("cmd", "x", "y", "b.go"), "matched", then)
+	events := receiveWithin(t, calls)
+	if len(events) != 1 || events[0].Path != filepath.Join("cmd", "x", "y", "b.go") {
+		t.Errorf("expected only cmd/x/y/b.go, got %v", events)
+	}
+	select {
+	case events := <-calls:
+		t.Errorf("unexpected call with %v", events)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+// A save made while fn runs (a build that takes a while) comes with the next
+// call, rather than being lost.
+func TestOnChangeSlowFn(t *testing.T) {
+	t.Chdir(t.TempDir())
+	calls, release := make(chan []Event, 10), make(chan struct{})
+	stop, err := OnChange([]string{"*.go"}, 50*time.Millisecond, func(events []Event) {
+		calls <- events
+		<-release
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	then := time.Now().Add(time.Hour)
+	writeFile(t, "a.go", "a", then)
+	if events := receiveWithin(t, calls); len(events) != 1 || events[0].Path != "a.go" {
+		t.Fatalf("expected a.go, got %v", events)
+	}
+	writeFile(t, "b.go", "b", then)
+	writeFile(t, "a.go", "aa", then.Add(time.Minute))
+	time.Sleep(1500 * time.Millisecond) // Longer than any DeliveryTimeout would wait
+	close(release)
+
+	got := make(map[string]Event)
+	for len(got) < 2 {
+		for _, ev := range receiveWithin(t, calls) {
+			got[ev.Path] = ev
+		}
+	}
+	if ev := got["b.go"]; ev.Type != Added {
+		t.Errorf("expected b.go as Added, got %v", got)
+	}
+	if ev := got["a.go"]; ev.Type != Changed || ev.Size() != 2 {
+		t.Errorf("expected a.go as Changed with its final size, got %v", got)
+	}
+}
@@ -0,0 +1,261 @@
+package directorywatcher
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// An Option configures a watcher in New.
+type Option func(dw *directoryWatcher) error
+
+// Scan every d, which is rounded down to whole milliseconds.
+func WithInterval(d time.Duration) Option {
+	return func(dw *directoryWatcher) error {
+		if d < time.Millisecond {
+			return fmt.Errorf("Interval must be at least 1ms: %s", d)
+		}
+		dw.Interval = uint64(d / time.Millisecond)
+		return nil
+	}
+}
+
+// Only watch files whose name matches pattern, as by filepath.Match, or with a
+// slash in pattern, whose path does (see the Pattern field).
+func WithPattern(pattern string) Option {
+	return func(dw *directoryWatcher) error {
+		if err := checkPath(pattern); err != nil {
+			return fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+		}
+		dw.Pattern = pattern
+		return nil
+	}
+}
+
+// Watch the files matching any of patterns, see the Patterns field and
+// WithPattern.
+func WithPatterns(patterns ...string) Option {
+	return func(dw *directoryWatcher) error {
+		for _, pattern := range patterns {
+			if err := checkPath(pattern); err != nil {
+				return fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+			}
+		}
+		dw.Patterns = append([]string(nil), patterns...)
+		return nil
+	}
+}
+
+// Watch the whole tree below the directory, not just the files directly in
+// it.
+func WithRecursive(recursive bool) Option {
+	return func(dw *directoryWatcher) error {
+		dw.Recursive = recursive
+		return nil
+	}
+}
+
+// Only watch files up to depth levels down, see the MaxDepth field.
+func WithMaxDepth(depth int) Option {
+	return func(dw *directoryWatcher) error {
+		if depth < 0 {
+			return fmt.Errorf("MaxDepth can't be negative: %d", depth)
+		}
+		dw.MaxDepth = depth
+		return nil
+	}
+}
+
+// Report a file as Changed whenever its size or modification time differs
+// from the last scan, not just when the modification time moved forward. See
+// the DetectModtimeRegression and DetectSizeChanges fields.
+func WithStrictComparison() Option {
+	return func(dw *directoryWatcher) error {
+		dw.DetectModtimeRegression = true
+		dw.DetectSizeChanges = true
+		return nil
+	}
+}
+
+// Decide with fn whether a file has changed, see the Comparator field.
+func WithComparator(fn func(old, new os.FileInfo, path string) bool) Option {
+	return func(dw *directoryWatcher) error {
+		if fn == nil {
+			return errors.New("WithComparator needs a comparison function")
+		}
+		dw.Comparator = fn
+		return nil
+	}
+}
+
+// Hold back the events on a path until it has been quiet for d, delivering a
+// single event for a burst of writes, see the Debounce field.
+func WithDebounce(d time.Duration) Option {
+	return func(dw *directoryWatcher) error {
+		if d < 0 {
+			return fmt.Errorf("Debounce can't be negative: %s", d)
+		}
+		dw.Debounce = d
+		return nil
+	}
+}
+
+// Deliver at most n batches per period, see the RateLimit field.
+func WithRateLimit(n int, period time.Duration) Option {
+	return func(dw *directoryWatcher) error {
+		if n <= 0 || period <= 0 {
+			return fmt.Errorf("Invalid rate limit: %d per %s", n, period)
+		}
+		dw.RateLimit, dw.RatePeriod = n, period
+		return nil
+	}
+}
+
+// Don't report the files found by the first scan, see the Preload field.
+func WithPreload() Option {
+	return func(dw *directoryWatcher) error {
+		dw.Preload = true
+		return nil
+	}
+}
+
+// Don't watch what the .gitignore in the watched directory ignores, see the
+// Gitignore field.
+func WithGitignore() Option {
+	return func(dw *directoryWatcher) error {
+		dw.Gitignore = true
+		return nil
+	}
+}
+
+// Don't watch what matches any of patterns, see Ignore.
+func WithIgnore(patterns ...string) Option {
+	return func(dw *directoryWatcher) error {
+		return dw.Ignore(patterns...)
+	}
+}
+
+// Only watch files with one of the given extensions, in addition to matching
+// Pattern (or Patterns). Extensions are matched case-insensitively, with or
+// without their leading dot, so "go", ".go" and ".GO" are the same.
+// Multi-part extensions such as ".tar.gz" work too.
+func WithExtensions(exts ...string) Option {
+	return func(dw *directoryWatcher) error {
+		for _, ext := range exts {
+			ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+			if ext != "" {
+				dw.extensions = append(dw.extensions, "."+ext)
+			}
+		}
+		return nil
+	}
+}
+
+// Without any extensions set, every name has one.
+func (dw *directoryWatcher) hasExtension(name string) bool {
+	if len(dw.extensions) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, ext := range dw.extensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Only watch files whose path below the watched directory, with slashes as
+// separators, matches re, in addition to matching Pattern (or Patterns); with
+// several WithRegexp, matching any of them will do. For example, any file
+// under cmd/ or internal/ ending in _gen.go:
+//
+//	WithRegexp(regexp.MustCompile(`^(cmd|internal)/.*_gen\.go$`))
+//
+// Unlike path patterns, a regexp doesn't keep recursive scans out of any
+// directories.
+func WithRegexp(re *regexp.Regexp) Option {
+	return func(dw *directoryWatcher) error {
+		if re == nil {
+			return errors.New("WithRegexp needs a regexp")
+		}
+		dw.regexps = append(dw.regexps, re)
+		return nil
+	}
+}
+
+// Without any regexps set, every path matches.
+func (dw *directoryWatcher) matchesRegexp(path string) bool {
+	if len(dw.regexps) == 0 {
+		return true
+	}
+	rel, err := filepath.Rel(dw.rootOf(path).path, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, re := range dw.regexps {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Only watch the given files, instead of everything in the directory. Each
+// scan looks at exactly these paths, reporting a file as Deleted when it
+// disappears and as Added when it (re)appears. Relative paths are taken to be
+// relative to the watched directory; with the Native backend, only files
+// directly in that directory are noticed.
+func WatchPaths(paths ...string) Option {
+	return func(dw *directoryWatcher) error {
+		if dw.watchPaths == nil {
+			dw.watchPaths = make(map[string]bool)
+		}
+		for _, p := range paths {
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(dw.path, p)
+			}
+			dw.watchPaths[filepath.Clean(p)] = true
+		}
+		return nil
+	}
+}
+
+// Scan with the given function (see ScanFunc) instead of one chosen from the
+// Recursive, FollowSymlinks and ParallelScan fields, which then have no effect
+// on scanning. Besides testing, this is for traversals of your own, such as
+// one that reads a manifest or stays on one filesystem.
+func WithScanner(scan ScanFunc) Option {
+	return func(dw *directoryWatcher) error {
+		if scan == nil {
+			return errors.New("WithScanner needs a scanning function")
+		}
+		dw.scan, dw.custom = scan, true
+		return nil
+	}
+}
+
+// Discover changes with the given backend, see the Backend field.
+func WithBackend(b Backend) Option {
+	return func(dw *directoryWatcher) error {
+		if b != Polling && b != Native {
+			return fmt.Errorf("Unknown backend: %d", b)
+		}
+		dw.Backend = b
+		return nil
+	}
+}
+
+// Log to l, see the Logger field.
+func WithLogger(l *slog.Logger) Option {
+	return func(dw *directoryWatcher) error {
+		dw.Logger = l
+		return nil
+	}
+}
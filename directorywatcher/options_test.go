@@ -0,0 +1,180 @@
+package directorywatcher
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithExtensions(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	names := []string{"main.go", "go.mod", "go.sum", "README.GO", "notes.txt", "mod", "archive.tar.gz", "archive.gz"}
+	for _, name := range names {
+		writeFile(t, filepath.Join(dir, name), name, then)
+	}
+
+	dw, err := New(dir, WithExtensions("go", ".MOD", ".sum", "tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw.scan2()
+
+	want := map[string]bool{"main.go": true, "go.mod": true, "go.sum": true, "README.GO": true, "archive.tar.gz": true}
+	for _, name := range names {
+		if _, ok := dw.files[filepath.Join(dir, name)]; ok != want[name] {
+			t.Errorf("%s: expected tracked=%v, got %v", name, want[name], ok)
+		}
+	}
+}
+
+func TestWithExtensionsAndPattern(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, name := range []string{"main.go", "main_test.go", "go.mod"} {
+		writeFile(t, filepath.Join(dir, name), name, then)
+	}
+
+	dw, err := New(dir, WithExtensions(".go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw.Pattern = "*_test.*"
+	if ev := onlyEvent(t, dw.scan2()); ev.Name() != "main_test.go" {
+		t.Errorf("expected only main_test.go, got %v", ev)
+	}
+}
+
+func TestWatchPaths(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, name := range []string{"a.conf", "b.conf", "c.conf"} {
+		writeFile(t, filepath.Join(dir, name), name, then)
+	}
+	aPath, bPath := filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")
+
+	dw, err := New(dir, WatchPaths("a.conf", bPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added := dw.scan2(); len(added) != 2 {
+		t.Fatalf("expected the two watched files to be added, got %v", added)
+	}
+
+	writeFile(t, filepath.Join(dir, "c.conf"), "changed", then.Add(time.Minute))
+	writeFile(t, filepath.Join(dir, "d.conf"), "new", then)
+	if changed := dw.scan2(); len(changed) != 0 {
+		t.Errorf("changes to unwatched files were reported: %v", changed)
+	}
+
+	writeFile(t, aPath, "changed", then.Add(time.Minute))
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Changed || ev.Path != aPath {
+		t.Errorf("expected a.conf to change, got %v", ev)
+	}
+
+	if err := os.Remove(bPath); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Deleted || ev.Path != bPath {
+		t.Errorf("expected b.conf to be deleted, got %v", ev)
+	}
+	writeFile(t, bPath, "back", then)
+	if ev := onlyEvent(t, dw.scan2()); ev.Type != Added || ev.Path != bPath {
+		t.Errorf("expected b.conf to be added again, got %v", ev)
+	}
+}
+
+func TestWithScanner(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	synthetic := func(root string) <-chan StrFileInfo {
+		c := make(chan StrFileInfo, 2)
+		c <- wrapFn(filepath.Join(root, "x.txt"), fakeInfo{"x.txt", 1, 0644, then})
+		c <- wrapFn(filepath.Join(root, "sub", "y.txt"), fakeInfo{"y.txt", 2, 0644, then})
+		close(c)
+		return c
+	}
+	dw, err := New(dir, WithScanner(synthetic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Recursive = true // Ignored with a custom scanner
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	fc.ticker(t)
+
+	events := receive(t, c).Events
+	if len(events) != 2 || events[0].Path != filepath.Join(dir, "sub", "y.txt") || events[1].Name() != "x.txt" {
+		t.Errorf("expected the synthetic files to be added, got %v", events)
+	}
+
+	if _, err := New(dir, WithScanner(nil)); err == nil {
+		t.Error("expected an error for a nil scanner")
+	}
+}
+
+func TestFieldOptions(t *testing.T) {
+	dir := t.TempDir()
+	dw, err := New(dir, WithInterval(500*time.Millisecond), WithPattern("*.go"), WithRecursive(true), WithPreload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dw.Interval != 500 || dw.Pattern != "*.go" || !dw.Recursive || !dw.Preload {
+		t.Errorf("options not applied: Interval %d, Pattern %q, Recursive %v, Preload %v",
+			dw.Interval, dw.Pattern, dw.Recursive, dw.Preload)
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(sub, "main.go"), "package main", time.Now())
+	writeFile(t, filepath.Join(sub, "notes.txt"), "notes", time.Now())
+	if ev := onlyEvent(t, dw.scan2()); ev.Name() != "main.go" {
+		t.Errorf("expected only the Go file below the directory, got %v", ev)
+	}
+
+	if _, err := New(dir, WithInterval(time.Microsecond)); err == nil {
+		t.Error("expected an error for an interval below 1ms")
+	}
+	if _, err := New(dir, WithPattern("[")); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+	if dw, err := New(dir, WithDebounce(300*time.Millisecond)); err != nil || dw.Debounce != 300*time.Millisecond {
+		t.Errorf("WithDebounce not applied: %v", err)
+	}
+	if _, err := New(dir, WithDebounce(-time.Second)); err == nil {
+		t.Error("expected an error for a negative debounce")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	dw, err := NewOpts(t.TempDir(), map[string]interface{}{"Logger": logger, "Bogus": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dw.Logger != logger {
+		t.Fatal("Logger not set from the options")
+	}
+	dw.scan2()
+	for _, want := range []string{"msg=\"Unused option\" name=Bogus value=1", "msg=Scanned"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in the log, got %s", want, buf.String())
+		}
+	}
+
+	dw, err = New(t.TempDir(), WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dw.scan2() // Silent
+}
@@ -0,0 +1,93 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// The entries of a directory, as listed by one of parallelScanner's workers.
+// Once done is closed, entries holds what to send for it and subdirs the
+// listings of the directories to descend into, which are under way already.
+type dirListing struct {
+	done    chan struct{}
+	entries []StrFileInfo
+	subdirs map[string]*dirListing
+}
+
+// Finds the same files as recScanner, in the same order, but lists and stats
+// directories with up to workers goroutines at a time (GOMAXPROCS if workers
+// is zero or less). Directories are listed ahead of the files being handed
+// over, so the listings of a whole tree may be held in memory at once.
+func parallelScanner(workers, buffer int, skip skipFn, report errorFn) ScanFunc {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return func(path string) <-chan StrFileInfo {
+		c := make(chan StrFileInfo, buffer)
+		go func() {
+			defer close(c)
+			info, err := os.Lstat(path)
+			if err != nil {
+				report(err)
+				return
+			}
+			c <- wrapFn(path, info)
+			if !info.IsDir() {
+				return
+			}
+
+			sem := make(chan struct{}, workers)
+			var list func(dir string) *dirListing
+			list = func(dir string) *dirListing {
+				l := &dirListing{done: make(chan struct{}), subdirs: make(map[string]*dirListing)}
+				go func() {
+					defer close(l.done)
+					sem <- struct{}{}
+					entries, err := os.ReadDir(dir)
+					if err != nil {
+						<-sem
+						report(err)
+						return
+					}
+					var dirs []string
+					for _, entry := range entries {
+						info, err := entry.Info()
+						if err != nil {
+							report(err)
+							continue
+						}
+						p := filepath.Join(dir, entry.Name())
+						if skip(p, info.IsDir()) {
+							continue
+						}
+						l.entries = append(l.entries, wrapFn(p, linkInfo(p, info)))
+						if info.IsDir() {
+							dirs = append(dirs, p)
+						}
+					}
+					<-sem
+					for _, p := range dirs {
+						l.subdirs[p] = list(p)
+					}
+				}()
+				return l
+			}
+
+			// Hand the entries over depth first, in the order of filepath.Walk
+			var send func(l *dirListing)
+			send = func(l *dirListing) {
+				<-l.done
+				for _, entry := range l.entries {
+					c <- entry
+					p, _ := entry()
+					if sub, ok := l.subdirs[p]; ok {
+						send(sub)
+					}
+				}
+			}
+			send(list(path))
+		}()
+		return c
+	}
+}
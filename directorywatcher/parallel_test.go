@@ -0,0 +1,97 @@
+package directorywatcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Builds a tree of the given depth, where every directory has width files and
+// width subdirectories.
+func nestedTree(t testing.TB, dir string, depth, width int) {
+	t.Helper()
+	for i := 0; i < width; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.txt", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if depth > 0 {
+			sub := filepath.Join(dir, fmt.Sprintf("d%d", i))
+			if err := os.Mkdir(sub, 0755); err != nil {
+				t.Fatal(err)
+			}
+			nestedTree(t, sub, depth-1, width)
+		}
+	}
+}
+
+func TestParallelScannerMatchesSequential(t *testing.T) {
+	root := t.TempDir()
+	nestedTree(t, root, 3, 4)
+
+	tracked := func(parallel bool, workers int) map[string]os.FileInfo {
+		dw := newTestWatcher(t, root)
+		dw.Recursive = true
+		dw.ParallelScan = parallel
+		dw.ScanWorkers = workers
+		dw.selectScanner()
+		scanWithin(t, dw, 5*time.Second)
+		return dw.files
+	}
+
+	want := tracked(false, 0)
+	if len(want) != 4+16+64+256 {
+		t.Fatalf("sequential scan found %d files", len(want))
+	}
+	for _, workers := range []int{0, 1, 3} {
+		got := tracked(true, workers)
+		if len(got) != len(want) {
+			t.Errorf("workers=%d: expected %d files, got %d", workers, len(want), len(got))
+		}
+		for path, info := range want {
+			if other, ok := got[path]; !ok || other.Size() != info.Size() || other.Mode() != info.Mode() {
+				t.Errorf("workers=%d: %s missing or different", workers, path)
+			}
+		}
+	}
+}
+
+func TestParallelScannerOrder(t *testing.T) {
+	root := t.TempDir()
+	nestedTree(t, root, 2, 3)
+	paths := func(scan ScanFunc) (paths []string) {
+		for pair := range scan(root) {
+			p, _ := pair()
+			paths = append(paths, p)
+		}
+		return
+	}
+	want := paths(recScanner(defaultScanBuffer, never, func(error) {}))
+	for _, workers := range []int{1, 4} {
+		got := paths(parallelScanner(workers, defaultScanBuffer, never, func(error) {}))
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("workers=%d: expected the order of filepath.Walk\n%v\ngot\n%v", workers, want, got)
+		}
+	}
+}
+
+func BenchmarkRecursiveScan(b *testing.B) {
+	root := b.TempDir()
+	nestedTree(b, root, 3, 7)
+	scanners := []struct {
+		name string
+		scan ScanFunc
+	}{
+		{"sequential", recScanner(defaultScanBuffer, never, func(error) {})},
+		{"parallel", parallelScanner(0, defaultScanBuffer, never, func(error) {})},
+	}
+	for _, s := range scanners {
+		b.Run(s.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for range s.scan(root) {
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package directorywatcher
+
+import "path/filepath"
+
+// PathMode selects how the paths of events are reported.
+type PathMode int
+
+const (
+	AsScanned      PathMode = iota // Joined to the root as it was passed to New
+	Absolute                       // Absolute, wherever the root was given relative to
+	RelativeToRoot                 // Relative to the watched directory, e.g. "sub/a.txt"
+)
+
+// The path of an event as reported under PathMode, relative to the root it is
+// in. Files outside every root (with WatchPaths) come out as "../..."
+// relative to the one passed to New.
+func (dw *directoryWatcher) reportedPath(path string) string {
+	if dw.PathMode == AsScanned {
+		return path
+	}
+	root := dw.rootOf(path)
+	rel, err := filepath.Rel(root.path, path)
+	if err != nil {
+		return path
+	}
+	if dw.PathMode == RelativeToRoot {
+		return rel
+	}
+	return filepath.Join(root.abs, rel)
+}
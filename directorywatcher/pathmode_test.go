@@ -0,0 +1,42 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathMode(t *testing.T) {
+	parent := t.TempDir()
+	sub := filepath.Join(parent, "root", "sub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(sub, "a.txt"), "a", time.Now())
+	t.Chdir(parent)
+
+	abs := filepath.Join(parent, "root", "sub", "a.txt")
+	tests := []struct {
+		root string
+		mode PathMode
+		want string
+	}{
+		{"root", AsScanned, filepath.Join("root", "sub", "a.txt")},
+		{filepath.Join(parent, "root"), AsScanned, abs},
+		{"root", Absolute, abs},
+		{filepath.Join(parent, "root"), Absolute, abs},
+		{"./root/", RelativeToRoot, filepath.Join("sub", "a.txt")},
+		{filepath.Join(parent, "root"), RelativeToRoot, filepath.Join("sub", "a.txt")},
+	}
+	for _, test := range tests {
+		dw := newTestWatcher(t, test.root)
+		dw.Recursive = true
+		dw.PathMode = test.mode
+		dw.selectScanner()
+		evAt := dw.batch(time.Now(), dw.scan2())
+		if ev := onlyEvent(t, evAt.Events); ev.Path != test.want {
+			t.Errorf("root %q, mode %d: got path %q, want %q", test.root, test.mode, ev.Path, test.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package directorywatcher
+
+import "sync/atomic"
+
+// Stops delivering batches without stopping the watcher: it keeps scanning and
+// tracking files, and collects the changes instead. Resume delivers them.
+func (dw *directoryWatcher) Pause() {
+	atomic.StoreInt32(&dw.paused, 1)
+}
+
+// Resumes delivering batches after Pause, starting right away with one batch
+// of everything that changed in the meantime, collapsed per path as by
+// Debounce. A watcher that isn't running delivers it once it's started.
+func (dw *directoryWatcher) Resume() {
+	if !atomic.CompareAndSwapInt32(&dw.paused, 1, 0) {
+		return
+	}
+	select {
+	case dw.wake <- struct{}{}:
+	default: // Already woken
+	}
+}
+
+func (dw *directoryWatcher) Paused() bool {
+	return atomic.LoadInt32(&dw.paused) == 1
+}
+
+// While paused, collects the events of evAt to deliver later, and returns true.
+func (dw *directoryWatcher) suspend(evAt EventsAt) bool {
+	if !dw.Paused() {
+		return false
+	}
+	if dw.missed == nil {
+		dw.missed = make(map[string]Event)
+	}
+	collapseInto(dw.missed, evAt.Events)
+	return true
+}
+
+// Puts the events collected while paused in front of those of evAt.
+func (dw *directoryWatcher) catchUp(evAt EventsAt) EventsAt {
+	if len(dw.missed) == 0 {
+		return evAt
+	}
+	collapseInto(dw.missed, evAt.Events)
+	evAt.Events = takeAll(dw.missed)
+	sortEvents(evAt.Events)
+	return evAt
+}
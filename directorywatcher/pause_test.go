@@ -0,0 +1,56 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	dir := t.TempDir()
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.Preload = true
+	c := dw.AddNewObserver()
+	dw.Pause()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	<-dw.Ready()
+
+	then := time.Now().Add(-time.Hour)
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	writeFile(t, a, "a", then)
+	writeFile(t, b, "b", then)
+	ft.c <- fc.now
+	writeFile(t, a, "a, again", then.Add(time.Minute))
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	ft.c <- fc.now
+	ft.c <- fc.now // Accepted once the previous scan is done
+	select {
+	case evAt := <-c:
+		t.Fatalf("batch delivered while paused: %v", evAt.Events)
+	default:
+	}
+	if len(dw.files) != 1 {
+		t.Errorf("expected a.txt to be tracked while paused, got %v", dw.files)
+	}
+
+	dw.Resume()
+	if dw.Paused() {
+		t.Error("still paused after Resume()")
+	}
+	ev := onlyEvent(t, receive(t, c).Events)
+	if ev.Type != Added || ev.Path != a || ev.Size() != int64(len("a, again")) {
+		t.Errorf("expected a single Added event for a.txt, got %v", ev)
+	}
+
+	writeFile(t, b, "b", then)
+	if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Added || ev.Path != b {
+		t.Errorf("expected b.txt to be added after resuming, got %v", ev)
+	}
+}
@@ -0,0 +1,55 @@
+package directorywatcher
+
+import "time"
+
+// The events that go out at now, out of those of a scan (or none, between
+// scans): debounced, then held for BatchWindow, then rate limited.
+func (dw *directoryWatcher) outgoing(now time.Time, events []Event) []Event {
+	return dw.limit(now, dw.hold(now, dw.debounce(now, events)))
+}
+
+// With a RateLimit, passes events on unless RateLimit batches already went
+// out in the last RatePeriod, in which case they're kept back and collapsed
+// into the next batch that is allowed. Without one (and nothing kept back),
+// events pass straight through.
+func (dw *directoryWatcher) limit(now time.Time, events []Event) []Event {
+	if !dw.rateLimited() && len(dw.limited) == 0 {
+		return events
+	}
+	if dw.limited == nil {
+		dw.limited = make(map[string]Event)
+	}
+	collapseInto(dw.limited, events)
+	if len(dw.limited) == 0 || (dw.rateLimited() && !dw.allowed(now)) {
+		return nil
+	}
+	dw.sentAt = append(dw.sentAt, now)
+	return takeAll(dw.limited)
+}
+
+func (dw *directoryWatcher) rateLimited() bool {
+	return dw.RateLimit > 0 && dw.RatePeriod > 0
+}
+
+// Whether another batch may go out at now, forgetting the batches that went
+// out before the current RatePeriod.
+func (dw *directoryWatcher) allowed(now time.Time) bool {
+	i := 0
+	for i < len(dw.sentAt) && !dw.sentAt[i].After(now.Add(-dw.RatePeriod)) {
+		i++
+	}
+	dw.sentAt = dw.sentAt[i:]
+	return len(dw.sentAt) < dw.RateLimit
+}
+
+// When the events kept back by the rate limit may go out, or the zero time if
+// there are none.
+func (dw *directoryWatcher) limitEnd() time.Time {
+	if len(dw.limited) == 0 {
+		return time.Time{}
+	}
+	if !dw.rateLimited() || len(dw.sentAt) < dw.RateLimit {
+		return dw.clock.Now()
+	}
+	return dw.sentAt[len(dw.sentAt)-dw.RateLimit].Add(dw.RatePeriod)
+}
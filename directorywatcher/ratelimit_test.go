@@ -0,0 +1,68 @@
+package directorywatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	dw, err := New(t.TempDir(), WithRateLimit(2, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	info := fakeInfo{name: "a", size: 1}
+	ev := func(typ eventType, path string) []Event {
+		return []Event{{typ, path, info, nil, ""}}
+	}
+
+	for i, path := range []string{"a", "b"} {
+		if got := dw.limit(now.Add(time.Duration(i)*time.Second), ev(Added, path)); len(got) != 1 {
+			t.Fatalf("batch %d held back within the limit: %v", i+1, got)
+		}
+	}
+	// Over the limit: kept back, and collapsed
+	if got := dw.limit(now.Add(2*time.Second), ev(Added, "c")); got != nil {
+		t.Errorf("released %v over the limit", got)
+	}
+	if got := dw.limit(now.Add(3*time.Second), ev(Changed, "c")); got != nil {
+		t.Errorf("released %v over the limit", got)
+	}
+	if end := dw.limitEnd(); !end.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected the kept back events due when the first batch expires, got %s", end.Sub(now))
+	}
+
+	got := dw.limit(now.Add(time.Minute), ev(Added, "d"))
+	if len(got) != 2 {
+		t.Fatalf("expected the kept back event along with the new one, got %v", got)
+	}
+	for _, ev := range got {
+		if ev.Path == "c" && ev.Type != Added {
+			t.Errorf("expected c collapsed into Added, got %v", ev)
+		}
+	}
+
+	if _, err := New(t.TempDir(), WithRateLimit(0, time.Minute)); err == nil {
+		t.Error("expected an error for a zero rate limit")
+	}
+}
+
+func TestStopFlushesRateLimitedEvents(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	dw.RateLimit, dw.RatePeriod = 1, time.Hour
+	c := dw.AddNewBufferedObserver(1, Block)
+	now := time.Now()
+	info := fakeInfo{name: "a", size: 1}
+	dw.limit(now, []Event{{Added, "a", info, nil, ""}})
+	dw.limit(now, []Event{{Added, "b", info, nil, ""}})
+
+	dw.flushPending()
+	select {
+	case evAt := <-c:
+		if ev := onlyEvent(t, evAt.Events); ev.Path != "b" {
+			t.Errorf("expected the kept back event for b, got %v", ev)
+		}
+	default:
+		t.Error("rate limited events not flushed")
+	}
+}
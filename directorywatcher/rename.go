@@ -0,0 +1,87 @@
+package directorywatcher
+
+import "os"
+
+// What a file renamed between two scans is recognized by: its size and modtime,
+// which a rename leaves alone, and its inode where there are inodes, so two
+// files that happen to agree on those aren't mistaken for each other.
+type renameKey struct {
+	id    [2]uint64
+	size  int64
+	mtime int64
+	dir   bool
+}
+
+func newRenameKey(info os.FileInfo) renameKey {
+	id, _ := fileID(info)
+	return renameKey{id, info.Size(), info.ModTime().UnixNano(), info.IsDir()}
+}
+
+// With DetectRenames, turns each Deleted and Added pair of the same scan that
+// is the same file into a single Renamed event. Pairs that are ambiguous (more
+// than one file with the same key on either side) are left as they are.
+func (dw *directoryWatcher) pairRenames(events []Event) []Event {
+	if !dw.DetectRenames {
+		return events
+	}
+	deleted := make(map[renameKey]int) // Index of the Deleted event, -1 if ambiguous
+	added := make(map[renameKey]int)
+	for i, ev := range events {
+		var m map[renameKey]int
+		var info os.FileInfo
+		switch ev.Type {
+		case Deleted:
+			m, info = deleted, ev.OldInfo
+		case Added:
+			m, info = added, ev.FileInfo
+		default:
+			continue
+		}
+		k := newRenameKey(info)
+		if _, ok := m[k]; ok {
+			m[k] = -1
+		} else {
+			m[k] = i
+		}
+	}
+
+	drop := make(map[int]bool)
+	for k, i := range added {
+		j, ok := deleted[k]
+		if i < 0 || !ok || j < 0 {
+			continue
+		}
+		from := events[j]
+		events[i].Type, events[i].OldInfo, events[i].OldPath = Renamed, from.OldInfo, from.Path
+		drop[j] = true
+	}
+	if len(drop) == 0 {
+		return events
+	}
+	paired := events[:0]
+	for i, ev := range events {
+		if !drop[i] {
+			paired = append(paired, ev)
+		}
+	}
+	return paired
+}
+
+// Where a rename is folded into an earlier event on the path it was renamed
+// from, ren being the Renamed event and prev the event on ren.OldPath: a file
+// that was added and then renamed was added under its new name, and one that
+// was renamed twice was renamed from where it was at first.
+func followRename(prev, ren Event) Event {
+	switch prev.Type {
+	case Added:
+		ren.Type, ren.OldInfo, ren.OldPath = Added, nil, ""
+	case Renamed:
+		ren.OldInfo, ren.OldPath = prev.OldInfo, prev.OldPath
+	default:
+		ren.OldInfo = prev.OldInfo
+	}
+	if ren.Type == Renamed && ren.OldPath == ren.Path {
+		ren.Type, ren.OldPath = Changed, "" // Renamed back again
+	}
+	return ren
+}
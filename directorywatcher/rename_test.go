@@ -0,0 +1,82 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDetectRenames(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	writeFile(t, a, "a", then)
+
+	dw := newTestWatcher(t, dir)
+	dw.DetectRenames = true
+	scanWithin(t, dw, time.Second)
+	if err := os.Rename(a, b); err != nil {
+		t.Fatal(err)
+	}
+	ev := onlyEvent(t, scanWithin(t, dw, time.Second))
+	if ev.Type != Renamed || ev.Path != b || ev.OldPath != a {
+		t.Errorf("expected Renamed event from a.txt to b.txt, got %v (from %q)", ev, ev.OldPath)
+	}
+	if ev.OldInfo == nil || ev.FileInfo == nil {
+		t.Errorf("expected both the old and the new FileInfo, got %v and %v", ev.OldInfo, ev.FileInfo)
+	}
+
+	// Written to as well, so it's not recognized
+	if err := os.Rename(b, a); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, a, "aa", then.Add(time.Minute))
+	if events := scanWithin(t, dw, time.Second); len(events) != 2 || events[0].Type == Renamed || events[1].Type == Renamed {
+		t.Errorf("expected Deleted and Added events, got %v", events)
+	}
+}
+
+func TestDetectRenamesAmbiguous(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, name := range []string{"a1", "a2"} {
+		writeFile(t, filepath.Join(dir, name), "x", then)
+	}
+	dw := newTestWatcher(t, dir)
+	dw.DetectRenames = true
+	scanWithin(t, dw, time.Second)
+	for _, name := range []string{"1", "2"} {
+		if err := os.Rename(filepath.Join(dir, "a"+name), filepath.Join(dir, "b"+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renamed := 0
+	for _, ev := range scanWithin(t, dw, time.Second) {
+		if ev.Type == Renamed {
+			renamed++
+			if filepath.Base(ev.OldPath)[1:] != filepath.Base(ev.Path)[1:] {
+				t.Errorf("paired the wrong files: %s and %s", ev.OldPath, ev.Path)
+			}
+		}
+	}
+	// With inodes, both renames are recognized; without them, neither is
+	if _, ok := fileID(dw.files[filepath.Join(dir, "b1")]); ok != (renamed == 2) {
+		t.Errorf("unexpected number of renames recognized: %d", renamed)
+	}
+}
+
+func TestDebouncedRename(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	dw.Debounce = time.Hour
+	now := time.Now()
+	info := fakeInfo{name: "a", size: 1}
+
+	dw.debounce(now, []Event{{Added, "a", info, nil, ""}})
+	dw.debounce(now, []Event{{Renamed, "b", info, info, "a"}})
+	dw.debounce(now, []Event{{Renamed, "c", info, info, "b"}})
+	ev := onlyEvent(t, dw.debounce(now.Add(2*time.Hour), nil))
+	if ev.Type != Added || ev.Path != "c" || ev.OldPath != "" {
+		t.Errorf("expected the file added as c, got %v (from %q)", ev, ev.OldPath)
+	}
+}
@@ -0,0 +1,95 @@
+package directorywatcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Waits for the watcher to report that its directory was removed.
+func rootRemoved(t *testing.T, dw *directoryWatcher) {
+	t.Helper()
+	select {
+	case err := <-dw.Errors():
+		if !errors.Is(err, ErrRootRemoved) {
+			t.Errorf("expected ErrRootRemoved, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("root removal not reported")
+	}
+}
+
+func TestRootRemoved(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "a", time.Now().Add(-time.Hour))
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	receive(t, c)
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Deleted || ev.Path != path {
+		t.Errorf("expected Deleted event for %s, got %v", path, ev)
+	}
+	rootRemoved(t, dw)
+	select {
+	case <-dw.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("watcher still running after its directory was removed")
+	}
+	if dw.Running() {
+		t.Error("Running() after the watcher stopped by itself")
+	}
+}
+
+func TestWaitForRoot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, path, "a", then)
+
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	dw.WaitForRoot = true
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	receive(t, c)
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Deleted {
+		t.Errorf("expected Deleted event, got %v", ev)
+	}
+	rootRemoved(t, dw)
+	ft.c <- fc.now // Ticks keep being accepted while waiting
+	if !dw.Running() {
+		t.Fatal("watcher stopped despite WaitForRoot")
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, "back", then)
+	if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Added || ev.Path != path {
+		t.Errorf("expected Added event once the directory was back, got %v", ev)
+	}
+	select {
+	case err := <-dw.Errors():
+		t.Errorf("unexpected error while waiting for the directory: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,66 @@
+package directorywatcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A watched directory, as it was given and made absolute.
+type rootDir struct {
+	path string
+	abs  string
+}
+
+// Also watch the directory at path, with the same fields, ticker and
+// observers, so the events of all the directories come in one batch per scan.
+// Like the fields, extra directories are added before Start (while the
+// watcher is running, AddPath returns an error); adding one that is already
+// watched does nothing. The Source of batches is still the
+// directory passed to New.
+//
+// Once a watched directory is removed, ErrRootRemoved is reported for it, but
+// the watcher only stops (or waits, with WaitForRoot) when all of them are
+// gone.
+func (dw *directoryWatcher) AddPath(path string) error {
+	if stat, err := os.Stat(path); err != nil {
+		return err
+	} else if !stat.IsDir() {
+		return fmt.Errorf("Provided path is not a directory: %s", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	if dw.running() {
+		return errRunning
+	}
+	dw.wait()
+	for _, root := range dw.roots {
+		if root.abs == abs {
+			return nil
+		}
+	}
+	dw.roots = append(dw.roots, rootDir{path, abs})
+	return nil
+}
+
+// The first watched directory path is in, or the one passed to New if it
+// isn't in any.
+func (dw *directoryWatcher) rootOf(path string) rootDir {
+	for _, root := range dw.roots {
+		if within(root.path, path) {
+			return root
+		}
+	}
+	return dw.roots[0]
+}
+
+// Whether path is dir or below it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
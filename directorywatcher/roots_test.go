@@ -0,0 +1,83 @@
+package directorywatcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddPath(t *testing.T) {
+	one, two := t.TempDir(), t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	a, b := filepath.Join(one, "a.txt"), filepath.Join(two, "b.txt")
+	writeFile(t, a, "a", then)
+	writeFile(t, b, "b", then)
+
+	dw := newTestWatcher(t, one)
+	if err := dw.AddPath(two); err != nil {
+		t.Fatal(err)
+	}
+	if err := dw.AddPath(two); err != nil || len(dw.roots) != 2 {
+		t.Errorf("adding a directory twice: err=%v, %d roots", err, len(dw.roots))
+	}
+	if err := dw.AddPath(a); err == nil {
+		t.Error("expected an error when adding a file")
+	}
+	dw.PathMode = RelativeToRoot
+	fc := newFakeClock()
+	dw.clock = fc
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+
+	baseline := receive(t, c)
+	if len(baseline.Events) != 2 || baseline.Events[0].Path != "a.txt" || baseline.Events[1].Path != "b.txt" {
+		t.Errorf("expected the files of both directories in the baseline, got %v", baseline.Events)
+	}
+
+	writeFile(t, a, "aa", then.Add(time.Minute))
+	writeFile(t, b, "bb", then.Add(time.Minute))
+	if evAt := ft.tickUntil(t, fc.now, c); len(evAt.Events) != 2 {
+		t.Errorf("expected both changes in one batch, got %v", evAt.Events)
+	}
+
+	// Losing one of the directories doesn't stop the watcher
+	if err := os.RemoveAll(two); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Deleted || ev.Path != "b.txt" {
+		t.Errorf("expected Deleted event for b.txt, got %v", ev)
+	}
+	rootRemoved(t, dw)
+	writeFile(t, a, "aaa", then.Add(2*time.Minute))
+	if ev := onlyEvent(t, ft.tickUntil(t, fc.now, c).Events); ev.Type != Changed || ev.Path != "a.txt" {
+		t.Errorf("expected Changed event for a.txt, got %v", ev)
+	}
+	if errs := scanErrors(dw); len(errs) != 0 {
+		t.Errorf("removal reported more than once: %v", errs)
+	}
+}
+
+// The scan goroutine reads the roots and ignore rules unlocked, so they can't
+// change under it.
+func TestAddPathAndIgnoreWhileRunning(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	dw.AddNewObserver()
+	dw.Start()
+	<-dw.Ready()
+	if err := dw.AddPath(t.TempDir()); err != errRunning {
+		t.Errorf("AddPath() while running = %v, want errRunning", err)
+	}
+	if err := dw.Ignore("*.tmp"); err != errRunning {
+		t.Errorf("Ignore() while running = %v, want errRunning", err)
+	}
+	dw.StopAndWait()
+	if err := dw.Ignore("*.tmp"); err != nil {
+		t.Errorf("Ignore() after Stop = %v", err)
+	}
+	if len(dw.roots) != 1 {
+		t.Errorf("expected only the directory passed to New, got %v", dw.roots)
+	}
+}
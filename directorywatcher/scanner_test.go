@@ -0,0 +1,375 @@
+package directorywatcher
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Runs a scan, failing the test if it doesn't finish in time.
+func scanWithin(t *testing.T, dw *directoryWatcher, d time.Duration) []Event {
+	t.Helper()
+	done := make(chan []Event, 1)
+	go func() { done <- dw.scan2() }()
+	select {
+	case events := <-done:
+		return events
+	case <-time.After(d):
+		t.Fatal("scan did not finish")
+	}
+	return nil
+}
+
+// A skipFn for scanners that leave nothing out.
+func never(string, bool) bool { return false }
+
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	other := filepath.Join(dir, "other")
+	for _, d := range []string{root, other} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(root, "a.txt"), "a", then)
+	writeFile(t, filepath.Join(other, "b.txt"), "b", then)
+	symlink(t, other, filepath.Join(root, "link"))
+	behindLink := filepath.Join(root, "link", "b.txt")
+
+	for _, follow := range []bool{false, true} {
+		dw := newTestWatcher(t, root)
+		dw.Recursive = true
+		dw.FollowSymlinks = follow
+		dw.selectScanner()
+		scanWithin(t, dw, time.Second)
+
+		if _, ok := dw.files[filepath.Join(root, "a.txt")]; !ok {
+			t.Errorf("FollowSymlinks=%v: a.txt not tracked", follow)
+		}
+		if _, ok := dw.files[behindLink]; ok != follow {
+			t.Errorf("FollowSymlinks=%v: expected file behind link tracked=%v, got %v", follow, follow, ok)
+		}
+		if _, ok := dw.files[filepath.Join(root, "link")]; ok {
+			t.Errorf("FollowSymlinks=%v: link to a directory tracked as a file", follow)
+		}
+	}
+}
+
+func TestSymlinkedFiles(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(t.TempDir(), "target.txt")
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, target, "t", then)
+	link := filepath.Join(root, "link.txt")
+	symlink(t, target, link)
+
+	for _, recursive := range []bool{false, true} {
+		for _, follow := range []bool{false, true} {
+			dw := newTestWatcher(t, root)
+			dw.Recursive = recursive
+			dw.FollowSymlinks = follow
+			dw.selectScanner()
+			scanWithin(t, dw, time.Second)
+			info, ok := dw.files[link]
+			if !ok {
+				t.Errorf("recursive=%v, FollowSymlinks=%v: link not tracked", recursive, follow)
+				continue
+			}
+			if isLink := info.Mode()&os.ModeSymlink != 0; isLink == follow {
+				t.Errorf("recursive=%v, FollowSymlinks=%v: expected the info of the link to be its own=%v", recursive, follow, !follow)
+			}
+
+			// Only a followed link sees its target change
+			writeFile(t, target, "tt", then.Add(time.Minute))
+			changed := len(scanWithin(t, dw, time.Second)) > 0
+			if changed != follow {
+				t.Errorf("recursive=%v, FollowSymlinks=%v: expected change of target reported=%v", recursive, follow, follow)
+			}
+			writeFile(t, target, "t", then)
+		}
+	}
+}
+
+func TestFollowSymlinksCycle(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(sub, "a.txt"), "a", time.Now().Add(-time.Hour))
+	symlink(t, root, filepath.Join(sub, "loop"))
+
+	dw := newTestWatcher(t, root)
+	dw.Recursive = true
+	dw.FollowSymlinks = true
+	dw.selectScanner()
+	scanWithin(t, dw, 2*time.Second)
+
+	if _, ok := dw.files[filepath.Join(sub, "a.txt")]; !ok {
+		t.Error("a.txt not tracked")
+	}
+}
+
+// A tree of 40 directories with 100 files each
+func syntheticTree(b *testing.B) string {
+	b.Helper()
+	root := b.TempDir()
+	for i := 0; i < 40; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%02d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < 100; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d", j)), nil, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return root
+}
+
+func BenchmarkRecScanner(b *testing.B) {
+	root := syntheticTree(b)
+	for _, buffer := range []int{0, defaultScanBuffer} {
+		b.Run(fmt.Sprintf("buffer=%d", buffer), func(b *testing.B) {
+			scan := recScanner(buffer, never, func(error) {})
+			for i := 0; i < b.N; i++ {
+				for range scan(root) {
+				}
+			}
+		})
+	}
+}
+
+// Collects the errors reported so far.
+func scanErrors(dw *directoryWatcher) (errs []error) {
+	for {
+		select {
+		case err := <-dw.Errors():
+			errs = append(errs, err)
+		default:
+			return
+		}
+	}
+}
+
+func TestUnreadableDirectoryDoesNotAbortScan(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	root := t.TempDir()
+	locked := filepath.Join(root, "a-locked")
+	for _, d := range []string{locked, filepath.Join(root, "b")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(locked, "hidden.txt"), "x", then)
+	writeFile(t, filepath.Join(root, "b", "visible.txt"), "x", then)
+	writeFile(t, filepath.Join(root, "top.txt"), "x", then)
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	for _, parallel := range []bool{false, true} {
+		dw := newTestWatcher(t, root)
+		dw.Recursive = true
+		dw.ParallelScan = parallel
+		dw.selectScanner()
+		scanWithin(t, dw, time.Second)
+
+		for _, p := range []string{filepath.Join(root, "b", "visible.txt"), filepath.Join(root, "top.txt")} {
+			if _, ok := dw.files[p]; !ok {
+				t.Errorf("parallel=%v: %s not tracked", parallel, p)
+			}
+		}
+		if errs := scanErrors(dw); len(errs) != 1 {
+			t.Errorf("parallel=%v: expected one error for the locked directory, got %v", parallel, errs)
+		}
+	}
+}
+
+func TestUnreadableDirectoryReportedByGlob(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.txt"), "x", time.Now())
+	dw := newTestWatcher(t, root)
+	if err := os.Chmod(root, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(root, 0755)
+
+	if events := scanWithin(t, dw, time.Second); len(events) != 0 {
+		t.Errorf("unexpected events from an unreadable directory: %v", events)
+	}
+	if errs := scanErrors(dw); len(errs) != 1 {
+		t.Errorf("expected one error for the unreadable directory, got %v", errs)
+	}
+}
+
+func TestBrokenSymlinkReported(t *testing.T) {
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(root, "a.txt"), "a", then)
+	writeFile(t, filepath.Join(root, "c.txt"), "c", then)
+	symlink(t, filepath.Join(root, "nowhere"), filepath.Join(root, "b-broken"))
+
+	for _, recursive := range []bool{false, true} {
+		dw := newTestWatcher(t, root)
+		dw.Recursive = recursive
+		dw.FollowSymlinks = true
+		dw.selectScanner()
+		scanWithin(t, dw, time.Second)
+
+		if len(dw.files) != 2 {
+			t.Errorf("recursive=%v: expected both regular files tracked, got %v", recursive, dw.files)
+		}
+		errs := scanErrors(dw)
+		if len(errs) != 1 || !errors.Is(errs[0], fs.ErrNotExist) {
+			t.Errorf("recursive=%v: expected a not-exist error for the broken link, got %v", recursive, errs)
+		}
+	}
+}
+
+func TestErrorsDoNotBlockScan(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 2*errorBuffer; i++ {
+		symlink(t, filepath.Join(root, "nowhere"), filepath.Join(root, fmt.Sprintf("broken%d", i)))
+	}
+	dw := newTestWatcher(t, root)
+	dw.FollowSymlinks = true // Otherwise broken links are just links
+	dw.selectScanner()
+	scanWithin(t, dw, time.Second)
+	if errs := scanErrors(dw); len(errs) != errorBuffer {
+		t.Errorf("expected the error buffer to fill up, got %d errors", len(errs))
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	root := t.TempDir()
+	broken := filepath.Join(root, "broken")
+	symlink(t, filepath.Join(root, "nowhere"), broken)
+
+	dw := newTestWatcher(t, root)
+	dw.FollowSymlinks = true
+	dw.selectScanner()
+	var handled []error
+	dw.SetErrorHandler(func(err error) { handled = append(handled, err) })
+	scanWithin(t, dw, time.Second)
+
+	if len(handled) != 1 {
+		t.Fatalf("expected one error for the handler, got %v", handled)
+	}
+	var serr *ScanError
+	if !errors.As(handled[0], &serr) {
+		t.Fatalf("expected a *ScanError, got %T", handled[0])
+	}
+	if serr.Path != broken || serr.Op != "stat" || !errors.Is(serr, fs.ErrNotExist) {
+		t.Errorf("unexpected ScanError %+v", serr)
+	}
+	if errs := scanErrors(dw); len(errs) != 0 {
+		t.Errorf("errors reported on the channel as well: %v", errs)
+	}
+
+	dw.SetErrorHandler(nil)
+	dw.files = make(map[string]os.FileInfo)
+	scanWithin(t, dw, time.Second)
+	if errs := scanErrors(dw); len(errs) != 1 || len(handled) != 1 {
+		t.Errorf("expected the channel to be used again, got %v (handler saw %d)", errs, len(handled))
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	root := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	for _, p := range []string{"a", "sub/b", "sub/deep/c", "sub/deep/er/d"} {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, p, "x", then)
+	}
+
+	for depth, want := range []int{4, 1, 2, 3} {
+		for _, parallel := range []bool{false, true} {
+			dw, err := New(root, WithRecursive(true), WithMaxDepth(depth))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dw.ParallelScan = parallel
+			dw.selectScanner()
+			scanWithin(t, dw, time.Second)
+			if len(dw.files) != want {
+				t.Errorf("MaxDepth=%d, parallel=%v: expected %d files tracked, got %v", depth, parallel, want, dw.files)
+			}
+		}
+	}
+	if _, err := New(root, WithMaxDepth(-1)); err == nil {
+		t.Error("expected an error for a negative MaxDepth")
+	}
+}
+
+func TestReportDirectories(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(sub, "a.txt"), "a", time.Now().Add(-time.Hour))
+
+	for _, recursive := range []bool{false, true} {
+		dw := newTestWatcher(t, root)
+		dw.Recursive = recursive
+		dw.ReportDirectories = true
+		dw.Pattern = "*.txt"
+		dw.selectScanner()
+		events := scanWithin(t, dw, time.Second)
+		var dirs []Event
+		for _, ev := range events {
+			if ev.IsDir() {
+				dirs = append(dirs, ev)
+			}
+		}
+		if ev := onlyEvent(t, dirs); ev.Type != Added || ev.Path != sub {
+			t.Errorf("recursive=%v: expected Added event for sub, got %v", recursive, ev)
+		}
+
+		// Adding a file changes the directory's modtime, which isn't reported
+		writeFile(t, filepath.Join(sub, "b.txt"), "b", time.Now())
+		for _, ev := range scanWithin(t, dw, time.Second) {
+			if ev.IsDir() {
+				t.Errorf("recursive=%v: unexpected directory event %v", recursive, ev)
+			}
+		}
+		if err := os.Remove(filepath.Join(sub, "b.txt")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dw := newTestWatcher(t, root)
+	dw.ReportDirectories = true
+	scanWithin(t, dw, time.Second)
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+	if ev := onlyEvent(t, scanWithin(t, dw, time.Second)); ev.Type != Deleted || ev.Path != sub || !ev.IsDir() {
+		t.Errorf("expected Deleted event for sub, got %v", ev)
+	}
+}
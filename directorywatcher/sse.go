@@ -0,0 +1,69 @@
+package directorywatcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How many batches a slow client may fall behind before the oldest are
+// dropped.
+const sseBuffer = 16
+
+// How often an idle stream gets a comment, so proxies don't close it.
+const sseKeepAlive = 30 * time.Second
+
+// ServeHTTP streams batches as server-sent events, so a watcher can be mounted
+// with
+//
+//	http.Handle("/events", dw)
+//
+// and followed from a browser with new EventSource("/events"). Every request
+// gets an observer of its own, which the batches are sent to as JSON (see
+// EventsAt), with the scan number as the event id. A client that doesn't keep
+// up misses the oldest batches rather than holding up the watcher; the stream
+// ends when the client goes away, or the observers are closed by
+// UnsubscribeAll.
+func (dw *directoryWatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	o := dw.AddNewBufferedObserver(sseBuffer, DropOldest)
+	defer dw.RemoveObserver(o)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case evAt, ok := <-o:
+			if !ok {
+				return // Closed by UnsubscribeAll
+			}
+			data, err := json.Marshal(evAt)
+			if err != nil {
+				dw.reportError(err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", evAt.ScanSeq, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
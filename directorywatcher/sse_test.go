@@ -0,0 +1,86 @@
+package directorywatcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeHTTP(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	srv := httptest.NewServer(dw)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+	if n := dw.ObserverCount(); n != 1 {
+		t.Fatalf("expected an observer for the request, got %d", n)
+	}
+	dw.send(EventsAt{ScanSeq: 3, Events: []Event{{Added, "a.txt", nil, nil, ""}}})
+
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(resp.Body)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+	if id := receiveWithin(t, lines); id != "id: 3" {
+		t.Errorf("expected the scan number as id, got %q", id)
+	}
+	data := receiveWithin(t, lines)
+	var evAt map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &evAt); err != nil {
+		t.Fatal(err)
+	}
+	if evAt["scanSeq"] != 3.0 || len(evAt["events"].([]interface{})) != 1 {
+		t.Errorf("unexpected batch %v", evAt)
+	}
+
+	resp.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for dw.ObserverCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("observer not removed when the client went away")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeHTTPClosedObserver(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	srv := httptest.NewServer(dw)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	dw.UnsubscribeAll(true)
+
+	body := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	select {
+	case b := <-body:
+		if b != "" {
+			t.Errorf("expected the stream to end without events, got %q", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream not ended when the observer was closed")
+	}
+}
@@ -0,0 +1,88 @@
+package directorywatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateVersion = 1
+
+// What SaveState writes: the tracked files, by the path they were found at
+type savedState struct {
+	Version int         `json:"version"`
+	Files   []savedFile `json:"files"`
+}
+
+type savedFile struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modtime"`
+}
+
+// A restored file implements os.FileInfo, so it can go in the files map like
+// the ones that were stat'ed (and in unmarshalled events). It has no Sys(), so
+// inodes and owners of restored files are unknown until they're scanned again.
+type restoredInfo struct {
+	name string
+	savedFile
+}
+
+func (fi restoredInfo) Name() string       { return fi.name }
+func (fi restoredInfo) Size() int64        { return fi.savedFile.Size }
+func (fi restoredInfo) Mode() os.FileMode  { return fi.savedFile.Mode }
+func (fi restoredInfo) ModTime() time.Time { return fi.savedFile.ModTime }
+func (fi restoredInfo) IsDir() bool        { return fi.savedFile.Mode.IsDir() }
+func (fi restoredInfo) Sys() interface{}   { return nil }
+
+var errRunning = errors.New("Watcher is running")
+
+// Writes the files tracked by the watcher (their path, size, mode and modtime)
+// to w as JSON, for LoadState to pick up. The watcher must not be running;
+// if it was just stopped, SaveState waits for it to deliver its last events.
+func (dw *directoryWatcher) SaveState(w io.Writer) error {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	if dw.running() {
+		return errRunning
+	}
+	dw.wait()
+	state := savedState{Version: stateVersion, Files: make([]savedFile, 0, len(dw.files))}
+	for path, info := range dw.files {
+		state.Files = append(state.Files, savedFile{path, info.Size(), info.Mode(), info.ModTime()})
+	}
+	return json.NewEncoder(w).Encode(state)
+}
+
+// Replaces the files tracked by the watcher with those written by SaveState,
+// so the first scan after Start reports whatever changed since then, rather
+// than every file as Initial. The watcher must not be running, and shouldn't use
+// Preload (which doesn't deliver the first scan). Paths are restored as they
+// were saved, so the watcher should watch the same directory, given the same
+// way.
+func (dw *directoryWatcher) LoadState(r io.Reader) error {
+	dw.runMu.Lock()
+	defer dw.runMu.Unlock()
+	if dw.running() {
+		return errRunning
+	}
+	dw.wait()
+	var state savedState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	if state.Version != stateVersion {
+		return fmt.Errorf("Unsupported state version: %d", state.Version)
+	}
+	files := make(map[string]os.FileInfo, len(state.Files))
+	for _, f := range state.Files {
+		files[f.Path] = restoredInfo{filepath.Base(f.Path), f}
+	}
+	dw.files = files
+	return nil
+}
@@ -0,0 +1,51 @@
+package directorywatcher
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	a, b, c := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")
+	writeFile(t, a, "a", then)
+	writeFile(t, b, "b", then)
+
+	dw := newTestWatcher(t, dir)
+	dw.scan2()
+	var state bytes.Buffer
+	if err := dw.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	// Meanwhile, while nothing was watching
+	writeFile(t, a, "aa", then.Add(time.Minute))
+	if err := os.Remove(b); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, c, "c", then)
+
+	dw = newTestWatcher(t, dir)
+	if err := dw.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+	if info := dw.files[b]; info == nil || info.Name() != "b" || info.Size() != 1 {
+		t.Errorf("b not restored: %v", info)
+	}
+	got := make(map[string]eventType)
+	for _, ev := range dw.scan2() {
+		got[ev.Path] = ev.Type
+	}
+	if len(got) != 3 || got[a] != Changed || got[b] != Deleted || got[c] != Added {
+		t.Errorf("expected a changed, b deleted and c added, got %v", got)
+	}
+
+	if err := dw.LoadState(strings.NewReader(`{"version": 99}`)); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
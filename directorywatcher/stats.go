@@ -0,0 +1,89 @@
+package directorywatcher
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of what a watcher has been doing, see Stats().
+type Stats struct {
+	Scans     uint64            `json:"scans"`     // Full scans performed
+	LastScan  time.Duration     `json:"lastScan"`  // How long the last scan took
+	ScanTime  time.Duration     `json:"scanTime"`  // How long all of them took
+	Files     int               `json:"files"`     // Files tracked
+	Events    map[string]uint64 `json:"events"`    // Events sent to the observers, by type ("Changed", ...)
+	Batches   uint64            `json:"batches"`   // Batches sent, heartbeats included
+	Observers int               `json:"observers"` // Observers attached
+	Dropped   uint64            `json:"dropped"`   // See Dropped()
+	Running   bool              `json:"running"`   // See Running()
+}
+
+// The counters behind Stats, updated by the watcher's goroutine.
+type statsCounters struct {
+	mu       sync.Mutex
+	scans    uint64
+	lastScan time.Duration
+	scanTime time.Duration
+	files    int
+	events   map[eventType]uint64
+	batches  uint64
+}
+
+func (s *statsCounters) scanned(took time.Duration, files int) {
+	s.mu.Lock()
+	s.scans++
+	s.lastScan = took
+	s.scanTime += took
+	s.files = files
+	s.mu.Unlock()
+}
+
+func (s *statsCounters) tracked(files int) {
+	s.mu.Lock()
+	s.files = files
+	s.mu.Unlock()
+}
+
+func (s *statsCounters) sent(events []Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.events == nil {
+		s.events = make(map[eventType]uint64)
+	}
+	for _, ev := range events {
+		s.events[ev.Type]++
+	}
+	s.batches++
+}
+
+// Returns the watcher's counters so far. Starting the watcher again after a
+// Stop() doesn't reset them.
+func (dw *directoryWatcher) Stats() Stats {
+	s := &dw.stats
+	s.mu.Lock()
+	stats := Stats{
+		Scans:    s.scans,
+		LastScan: s.lastScan,
+		ScanTime: s.scanTime,
+		Files:    s.files,
+		Events:   make(map[string]uint64, len(s.events)),
+		Batches:  s.batches,
+	}
+	for typ, n := range s.events {
+		stats.Events[typ.String()] = n
+	}
+	s.mu.Unlock()
+	stats.Observers = dw.ObserverCount()
+	stats.Dropped = dw.Dropped()
+	stats.Running = dw.Running()
+	return stats
+}
+
+// Publishes Stats() as the expvar variable name, so it shows up on
+// /debug/vars. Like expvar.Publish, this panics if name is already taken.
+func (dw *directoryWatcher) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return dw.Stats()
+	}))
+}
@@ -0,0 +1,48 @@
+package directorywatcher
+
+import (
+	"expvar"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	then := time.Now().Add(-time.Hour)
+	writeFile(t, filepath.Join(dir, "a.txt"), "a", then)
+	dw := newTestWatcher(t, dir)
+	fc := newFakeClock()
+	dw.clock = fc
+	c := dw.AddNewObserver()
+	dw.Start()
+	defer dw.Stop()
+	ft := fc.ticker(t)
+	receive(t, c)
+
+	writeFile(t, filepath.Join(dir, "b.txt"), "b", then)
+	writeFile(t, filepath.Join(dir, "a.txt"), "aa", then.Add(time.Minute))
+	ft.tickUntil(t, fc.now, c)
+
+	stats := dw.Stats()
+	if stats.Scans < 2 || stats.ScanTime < stats.LastScan {
+		t.Errorf("unexpected scan counters: %+v", stats)
+	}
+	if stats.Files != 2 || stats.Batches != 2 || stats.Observers != 1 || !stats.Running {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	want := map[string]uint64{"Initial": 1, "Added": 1, "Changed": 1}
+	for typ, n := range want {
+		if stats.Events[typ] != n {
+			t.Errorf("expected %d %s events, got %d", n, typ, stats.Events[typ])
+		}
+	}
+
+	name := fmt.Sprintf("TestStats-%d", time.Now().UnixNano()) // Unique, also with -count
+	dw.PublishExpvar(name)
+	if v := expvar.Get(name); v == nil || !strings.Contains(v.String(), `"files":2`) {
+		t.Errorf("unexpected expvar: %v", v)
+	}
+}
@@ -0,0 +1,56 @@
+package directorywatcher
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+var errUnsubscribed = errors.New("Observer was unsubscribed")
+
+// Blocks until the next event and returns it, or ctx's error if it's done
+// first. The watcher has to be running (or be started by someone else) for
+// anything to arrive.
+func (dw *directoryWatcher) WaitForChange(ctx context.Context) (Event, error) {
+	return dw.waitFor(ctx, nil)
+}
+
+// Like WaitForChange, but waits for an event on a path matching glob. A glob
+// with a separator matches the event's path, others its file name:
+//
+//	ev, err := dw.WaitForPath(ctx, "*.pid")
+func (dw *directoryWatcher) WaitForPath(ctx context.Context, glob string) (Event, error) {
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return Event{}, err
+	}
+	return dw.waitFor(ctx, func(ev Event) bool {
+		return matchesGlob(glob, ev.Path)
+	})
+}
+
+func (dw *directoryWatcher) waitFor(ctx context.Context, accept func(Event) bool) (Event, error) {
+	o := dw.AddFilteredObserver(accept)
+	defer dw.RemoveObserver(o)
+	for {
+		select {
+		case evAt, ok := <-o:
+			if !ok {
+				return Event{}, errUnsubscribed
+			}
+			if len(evAt.Events) > 0 {
+				return evAt.Events[0], nil
+			}
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		}
+	}
+}
+
+func matchesGlob(glob, path string) bool {
+	name := filepath.Base(path)
+	if strings.ContainsRune(glob, filepath.Separator) {
+		name = path
+	}
+	return matches(glob, name)
+}
@@ -0,0 +1,42 @@
+package directorywatcher
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitFor(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	info := fakeInfo{name: "a.pid", size: 1}
+	go func() {
+		for dw.ObserverCount() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		dw.send(EventsAt{ScanSeq: 1, Events: []Event{{Added, "a.txt", info, nil, ""}}})
+		dw.send(EventsAt{ScanSeq: 2, Events: []Event{{Added, filepath.Join("run", "a.pid"), info, nil, ""}}})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ev, err := dw.WaitForPath(ctx, "*.pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Path != filepath.Join("run", "a.pid") {
+		t.Errorf("expected the event for run/a.pid, got %v", ev)
+	}
+	if n := dw.ObserverCount(); n != 0 {
+		t.Errorf("expected the observer to be removed, got %d", n)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := dw.WaitForChange(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the context's error, got %v", err)
+	}
+	if _, err := dw.WaitForPath(context.Background(), "["); err == nil {
+		t.Error("expected an error for a malformed glob")
+	}
+}
@@ -0,0 +1,101 @@
+package directorywatcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// A Webhook POSTs every batch as JSON to URL, see AddWebhook.
+type Webhook struct {
+	URL    string
+	Header http.Header  // Extra request headers, such as Authorization
+	Client *http.Client // http.DefaultClient if nil
+
+	// How long an attempt may take, 10s if zero.
+	Timeout time.Duration
+
+	// How many more times to try after a failed attempt (a network error, or
+	// a 5xx or 429 response), waiting RetryDelay before the first retry and
+	// twice as long before each one after that. RetryDelay is 1s if zero.
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// Delivers every batch to the webhook, from a goroutine of its own, as
+// OnEvent does. A batch that couldn't be delivered after all retries is
+// reported on Errors(), and the webhook goes on with the next one. Calling the
+// returned stop detaches the webhook and abandons a delivery in progress.
+func (dw *directoryWatcher) AddWebhook(h Webhook) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	o := NewObserver()
+	dw.AddObserver(o)
+	stopRun := dw.run(o, func(evAt EventsAt) {
+		if err := h.deliver(ctx, evAt); err != nil && ctx.Err() == nil {
+			dw.reportError(err)
+		}
+	})
+	return func() {
+		cancel()
+		stopRun()
+	}
+}
+
+func (h Webhook) deliver(ctx context.Context, evAt EventsAt) error {
+	body, err := json.Marshal(evAt)
+	if err != nil {
+		return err
+	}
+	delay := h.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := h.post(ctx, body)
+		if err == nil || !retry || attempt >= h.Retries {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Makes one attempt at delivering body, telling whether a failure is worth
+// retrying.
+func (h Webhook) post(ctx context.Context, body []byte) (retry bool, err error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("Webhook %s responded %s", h.URL, resp.Status)
+}
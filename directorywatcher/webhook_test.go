@@ -0,0 +1,79 @@
+package directorywatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var attempts int32
+	received := make(chan map[string]interface{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("X-Token") != "secret" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		received <- body
+	}))
+	defer srv.Close()
+
+	dw := newTestWatcher(t, t.TempDir())
+	stop := dw.AddWebhook(Webhook{
+		URL:        srv.URL,
+		Header:     http.Header{"X-Token": {"secret"}},
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+	})
+	defer stop()
+	dw.send(EventsAt{ScanSeq: 7, Events: []Event{{Added, "a.txt", nil, nil, ""}}})
+
+	select {
+	case body := <-received:
+		if body["scanSeq"] != 7.0 {
+			t.Errorf("unexpected body %v", body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("expected one retry, got %d attempts", n)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest) // Not worth retrying
+	}))
+	defer srv.Close()
+
+	dw := newTestWatcher(t, t.TempDir())
+	stop := dw.AddWebhook(Webhook{URL: srv.URL, Retries: 3, RetryDelay: time.Millisecond})
+	defer stop()
+	dw.send(EventsAt{ScanSeq: 1})
+
+	select {
+	case err := <-dw.Errors():
+		if err == nil || errors.Is(err, ErrRootRemoved) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("failed delivery not reported")
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Errorf("expected no retries for a 400, got %d attempts", n)
+	}
+}
@@ -0,0 +1,64 @@
+package directorywatcher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// LineFormat is how AddWriter writes batches out.
+type LineFormat int
+
+const (
+	// A line per event, with the time of its scan, such as
+	//
+	//	2026-10-14T09:30:00Z Changed src/main.go
+	//	2026-10-14T09:30:00Z Renamed old.txt -> new.txt
+	TextLines LineFormat = iota
+
+	// A line per batch, holding it as JSON (see EventsAt), which can be read
+	// back with a json.Decoder.
+	JSONLines
+)
+
+// Writes every batch to w in format, from a goroutine of its own, as OnEvent
+// does; e.g. dw.AddWriter(os.Stdout, TextLines). A batch is written with a
+// single call to w, so several watchers can share it line by line. Write
+// errors are reported on Errors(). Calling the returned stop detaches w.
+func (dw *directoryWatcher) AddWriter(w io.Writer, format LineFormat) (stop func()) {
+	return dw.OnEvent(func(evAt EventsAt) {
+		b, err := format.lines(evAt)
+		if err != nil {
+			dw.reportError(err)
+			return
+		}
+		if len(b) == 0 {
+			return
+		}
+		if _, err := w.Write(b); err != nil {
+			dw.reportError(err)
+		}
+	})
+}
+
+func (format LineFormat) lines(evAt EventsAt) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case JSONLines:
+		if err := json.NewEncoder(&buf).Encode(evAt); err != nil {
+			return nil, err
+		}
+	default:
+		at := evAt.At.Format(time.RFC3339)
+		for _, ev := range evAt.Events {
+			if ev.Type == Renamed {
+				fmt.Fprintf(&buf, "%s %s %s -> %s\n", at, ev.Type, ev.OldPath, ev.Path)
+			} else {
+				fmt.Fprintf(&buf, "%s %s\n", at, ev)
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
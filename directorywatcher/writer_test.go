@@ -0,0 +1,67 @@
+package directorywatcher
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An io.Writer that passes every write on to a channel.
+type chanWriter chan string
+
+func (w chanWriter) Write(b []byte) (int, error) {
+	w <- string(b)
+	return len(b), nil
+}
+
+func TestAddWriter(t *testing.T) {
+	at := time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC)
+	evAt := EventsAt{At: at, ScanSeq: 2, Events: []Event{
+		{Changed, "a.txt", nil, nil, ""},
+		{Renamed, "c.txt", nil, nil, "b.txt"},
+	}}
+
+	dw := newTestWatcher(t, t.TempDir())
+	text := make(chanWriter)
+	stop := dw.AddWriter(text, TextLines)
+	dw.send(evAt)
+	want := "2026-10-14T09:30:00Z Changed a.txt\n2026-10-14T09:30:00Z Renamed b.txt -> c.txt\n"
+	if got := receiveWithin(t, text); got != want {
+		t.Errorf("expected\n%sgot\n%s", want, got)
+	}
+	stop()
+
+	lines := make(chanWriter)
+	defer dw.AddWriter(lines, JSONLines)()
+	dw.send(evAt)
+	got := receiveWithin(t, lines)
+	if !strings.HasSuffix(got, "}\n") || strings.Count(got, "\n") != 1 {
+		t.Errorf("expected a single line of JSON, got %q", got)
+	}
+	var back EventsAt
+	if err := json.Unmarshal([]byte(got), &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.ScanSeq != 2 || len(back.Events) != 2 || back.Events[1].OldPath != "b.txt" {
+		t.Errorf("unexpected batch %+v", back)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAddWriterError(t *testing.T) {
+	dw := newTestWatcher(t, t.TempDir())
+	errs := make(chan error, 1)
+	dw.SetErrorHandler(func(err error) { errs <- err })
+	defer dw.AddWriter(failingWriter{}, TextLines)()
+	dw.send(EventsAt{Events: []Event{{Added, "a.txt", nil, nil, ""}}})
+	if err := receiveWithin(t, errs); !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("expected the write error, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package env
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/laumann/goutil/directorywatcher"
+)
+
+// Reads a dotenv file: KEY=value lines, optionally preceded by "export ".
+// Blank lines and lines starting with # are skipped, and values may be quoted
+// with ' or ", which are removed.
+func Load(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("Invalid line %d in %s: %q", n, path, line)
+		}
+		m[key] = unquote(strings.TrimSpace(kv[1]))
+	}
+	return m, scanner.Err()
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// Sets every variable of m in the environment. Stops at the first variable
+// that can't be set.
+func Apply(m map[string]string) error {
+	for _, k := range sortedKeys(m) {
+		if err := os.Setenv(k, m[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watches a dotenv file, and whenever it changes, loads it again, applies the
+// variables that were added or changed, unsets the ones that were removed and
+// calls onReload with all of them (removed ones with an empty value). The file
+// is loaded once up front, as what the first change is compared against, but
+// not applied. A file that doesn't parse is skipped until it's changed again.
+//
+// Changes are picked up once the file has been quiet for a moment, so a save
+// isn't read half-way. Call stop to stop watching; onReload isn't called after
+// stop returns.
+func WatchFile(path string, onReload func(changed map[string]string)) (stop func(), err error) {
+	prev, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	dw, err := directorywatcher.NewFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dw.Backend = directorywatcher.Native
+	dw.Preload = true
+	dw.Debounce = 100 * time.Millisecond // Let a save finish before reading the file
+
+	// A change that comes in while onReload runs is passed on once it has
+	// returned, and the file then read again
+	stopReload := dw.OnEventsCoalesced(func([]directorywatcher.Event) {
+		cur, err := Load(path)
+		if err != nil {
+			return
+		}
+		changed := changes(prev, cur)
+		prev = cur
+		if len(changed) == 0 {
+			return
+		}
+		Apply(Subset(cur, sortedKeys(changed)...))
+		for k := range changed {
+			if _, ok := cur[k]; !ok {
+				os.Unsetenv(k)
+			}
+		}
+		onReload(changed)
+	})
+	dw.Start()
+	return func() {
+		dw.Stop()
+		stopReload()
+	}, nil
+}
+
+// The variables that differ between old and cur, with their values in cur
+// (empty for the removed ones).
+func changes(old, cur map[string]string) map[string]string {
+	added, removed, changed := Diff(old, cur)
+	for k, v := range added {
+		changed[k] = v
+	}
+	for k := range removed {
+		changed[k] = ""
+	}
+	return changed
+}
@@ -0,0 +1,165 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeDotenv(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	writeDotenv(t, path, `# Settings
+NAME=app
+export PORT = 8080
+
+GREETING="hello, world"
+SINGLE='a=b'
+EMPTY=
+`, time.Now())
+
+	m, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"NAME": "app", "PORT": "8080", "GREETING": "hello, world", "SINGLE": "a=b", "EMPTY": ""}
+	if len(m) != len(want) {
+		t.Errorf("Load() = %v, want %v", m, want)
+	}
+	for k, v := range want {
+		if got, ok := m[k]; !ok || got != v {
+			t.Errorf("Load()[%q] = %q, want %q", k, got, v)
+		}
+	}
+
+	writeDotenv(t, path, "NAME=app\nnot a variable\n", time.Now())
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for a line without =")
+	}
+}
+
+func TestApply(t *testing.T) {
+	os.Clearenv()
+	if err := Apply(map[string]string{"A": "1", "B": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if got := Map(); len(got) != 2 || got["A"] != "1" || !Has("B") {
+		t.Errorf("environment after Apply() = %v, want A and B", got)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	os.Clearenv()
+	path := filepath.Join(t.TempDir(), ".env")
+	then := time.Now().Add(-time.Hour)
+	writeDotenv(t, path, "NAME=app\nPORT=8080\nDEBUG=false\n", then)
+	Apply(map[string]string{"NAME": "app", "PORT": "8080", "DEBUG": "false"})
+
+	reloads := make(chan map[string]string, 10)
+	stop, err := WatchFile(path, func(changed map[string]string) {
+		reloads <- changed
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	writeDotenv(t, path, "NAME=app\nPORT=9090\nLEVEL=debug\n", then.Add(time.Hour*2))
+	var changed map[string]string
+	select {
+	case changed = <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onReload not called after the file changed")
+	}
+	if len(changed) != 3 || changed["PORT"] != "9090" || changed["LEVEL"] != "debug" || changed["DEBUG"] != "" {
+		t.Errorf("onReload(%v), want PORT, LEVEL and DEBUG", changed)
+	}
+	if os.Getenv("PORT") != "9090" || os.Getenv("LEVEL") != "debug" || Has("DEBUG") {
+		t.Errorf("environment not updated: %v", Map())
+	}
+
+	stop()
+	writeDotenv(t, path, "NAME=other\n", then.Add(time.Hour*3))
+	select {
+	case changed := <-reloads:
+		t.Errorf("onReload(%v) called after stop", changed)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// A relative path is taken as it is, not relative to the directory it's in.
+func TestWatchFileRelative(t *testing.T) {
+	os.Clearenv()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("cfg", 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join("cfg", "app.env")
+	then := time.Now().Add(-time.Hour)
+	writeDotenv(t, path, "PORT=8080\n", then)
+
+	reloads := make(chan map[string]string, 10)
+	stop, err := WatchFile(path, func(changed map[string]string) {
+		reloads <- changed
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	writeDotenv(t, path, "PORT=9090\n", then.Add(time.Hour*2))
+	select {
+	case changed := <-reloads:
+		if changed["PORT"] != "9090" {
+			t.Errorf("onReload(%v), want PORT", changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("onReload not called after the file changed")
+	}
+}
+
+// A change made while onReload runs is still loaded afterwards.
+func TestWatchFileSlowReload(t *testing.T) {
+	os.Clearenv()
+	path := filepath.Join(t.TempDir(), ".env")
+	then := time.Now().Add(-time.Hour)
+	writeDotenv(t, path, "PORT=8080\n", then)
+
+	reloads, release := make(chan map[string]string, 10), make(chan struct{})
+	stop, err := WatchFile(path, func(changed map[string]string) {
+		reloads <- changed
+		<-release
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	writeDotenv(t, path, "PORT=9090\n", then.Add(time.Hour*2))
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onReload not called after the file changed")
+	}
+	writeDotenv(t, path, "PORT=9191\n", then.Add(time.Hour*3))
+	time.Sleep(1500 * time.Millisecond) // onReload is still running
+	close(release)
+	select {
+	case changed := <-reloads:
+		if changed["PORT"] != "9191" {
+			t.Errorf("onReload(%v), want the latest PORT", changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("change made during onReload was lost")
+	}
+}
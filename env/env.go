@@ -1,16 +1,367 @@
 package env
 
 import (
+	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"time"
 )
 
 // Get the environment as a map[string]string
 func Map() map[string]string {
+	return MapFunc(func(key, val string) (string, string, bool) {
+		return key, val, true
+	})
+}
+
+// Like Map(), but every variable is passed through fn first, which returns
+// the key and value to store, or false to leave the variable out.
+func MapFunc(fn func(key, val string) (string, string, bool)) map[string]string {
 	env := make(map[string]string)
 	for _, v := range os.Environ() {
-		kv := strings.Split(v, "=")
-		env[kv[0]] = kv[1]
+		kv := strings.SplitN(v, "=", 2)
+		if key, val, ok := fn(kv[0], kv[1]); ok {
+			env[key] = val
+		}
 	}
 	return env
 }
+
+// The inverse of Map(): turns m into KEY=value entries, like os.Environ()
+// returns them (suitable for exec.Cmd.Env). The entries are sorted by key.
+func Environ(m map[string]string) []string {
+	keys := sortedKeys(m)
+	environ := make([]string, len(keys))
+	for i, k := range keys {
+		environ[i] = k + "=" + m[k]
+	}
+	return environ
+}
+
+// Look up a single variable. Unlike os.Getenv, ok tells a variable that is set
+// to the empty string apart from one that isn't set at all.
+func Lookup(key string) (value string, ok bool) {
+	return os.LookupEnv(key)
+}
+
+// Whether the variable is set, even if empty.
+func Has(key string) bool {
+	_, ok := os.LookupEnv(key)
+	return ok
+}
+
+// Resolves ${VAR} and $VAR references in the values of m, like os.Expand.
+// References are looked up in m first (and expanded in turn), then in the
+// process environment; anything not found expands to the empty string.
+// References that would loop are left in place as ${VAR}, where they come
+// back to the variable being expanded: A=${B} with B=x${A} gives A=x${A} and
+// B=x${B}.
+func Expand(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	done := make(map[string]string, len(m)) // Values that can be reused
+	visiting := make(map[string]bool)
+
+	// A value that ran into a loop depends on where the expansion started,
+	// so only the others are kept for reuse.
+	var resolve func(key string) (v string, looped bool)
+	resolve = func(key string) (string, bool) {
+		if v, ok := done[key]; ok {
+			return v, false
+		}
+		visiting[key] = true
+		looped := false
+		v := os.Expand(m[key], func(name string) string {
+			if _, ok := m[name]; !ok {
+				return os.Getenv(name)
+			}
+			if visiting[name] {
+				looped = true
+				return "${" + name + "}"
+			}
+			v, l := resolve(name)
+			looped = looped || l
+			return v
+		})
+		delete(visiting, key)
+		if !looped {
+			done[key] = v
+		}
+		return v, looped
+	}
+
+	for k := range m {
+		out[k], _ = resolve(k)
+	}
+	return out
+}
+
+// Reads a variable and resolves the ${VAR} and $VAR references in its value
+// against the process environment, like os.ExpandEnv. As with os.Expand,
+// references to variables that aren't set expand to the empty string. The
+// expansion isn't recursive: a referenced variable's own value is used as is.
+func GetExpanded(key string) string {
+	return os.ExpandEnv(os.Getenv(key))
+}
+
+// Like GetExpanded, but returns def (unexpanded) if the variable isn't set.
+func GetExpandedOr(key, def string) string {
+	if !Has(key) {
+		return def
+	}
+	return GetExpanded(key)
+}
+
+// Returns a new map with the keys of base and overlay. Keys in both keep the
+// value from base, unless overwrite is set.
+func Merge(base, overlay map[string]string, overwrite bool) map[string]string {
+	merged := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		if _, ok := merged[k]; !ok || overwrite {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Merges the maps left to right, later maps taking precedence, e.g.
+//
+//	MergeAll(defaults, dotenv, Map())
+func MergeAll(maps ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Checks that all the given variables are set (possibly to the empty string),
+// returning an error naming every one that isn't.
+func Require(keys ...string) error {
+	var missing []string
+	for _, k := range keys {
+		if !Has(k) {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Like os.Getenv, but panics if the variable isn't set.
+func MustGet(key string) string {
+	v, ok := Lookup(key)
+	if !ok {
+		panic(fmt.Sprintf("Required environment variable not set: %s", key))
+	}
+	return v
+}
+
+// A variable and its value
+type Pair struct {
+	Key, Value string
+}
+
+// Variables in a fixed order, see OrderedMap().
+type Ordered []Pair
+
+// The environment in the order os.Environ() lists it, which doesn't change
+// within a process (unless the environment does). Environ(o.Map()) gives the
+// same entries, but sorted.
+func OrderedMap() Ordered {
+	environ := os.Environ()
+	o := make(Ordered, 0, len(environ))
+	for _, v := range environ {
+		kv := strings.SplitN(v, "=", 2)
+		o = append(o, Pair{kv[0], kv[1]})
+	}
+	return o
+}
+
+// Looks up a variable, like Lookup does in the environment.
+func (o Ordered) Get(key string) (value string, ok bool) {
+	for _, p := range o {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// The variables as a map, forgetting their order.
+func (o Ordered) Map() map[string]string {
+	m := make(map[string]string, len(o))
+	for _, p := range o {
+		if _, ok := m[p.Key]; !ok {
+			m[p.Key] = p.Value
+		}
+	}
+	return m
+}
+
+// The names of all variables in the environment, sorted.
+func Keys() []string {
+	return sortedKeys(Map())
+}
+
+// The environment as key/value pairs, sorted by key.
+func SortedPairs() []Pair {
+	env := Map()
+	pairs := make([]Pair, 0, len(env))
+	for _, k := range sortedKeys(env) {
+		pairs = append(pairs, Pair{k, env[k]})
+	}
+	return pairs
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Unsets every variable whose name starts with prefix, returning how many were
+// removed. Unlike os.Clearenv, the rest of the environment is left alone.
+func ClearPrefix(prefix string) int {
+	var keys []string
+	for k := range Map() {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		os.Unsetenv(k)
+	}
+	return len(keys)
+}
+
+// A copy of m with only the given keys, skipping those that m doesn't have.
+func Subset(m map[string]string, keys ...string) map[string]string {
+	subset := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			subset[k] = v
+		}
+	}
+	return subset
+}
+
+// A copy of m without the given keys.
+func Without(m map[string]string, keys ...string) map[string]string {
+	without := make(map[string]string, len(m))
+	for k, v := range m {
+		without[k] = v
+	}
+	for _, k := range keys {
+		delete(without, k)
+	}
+	return without
+}
+
+// Looks up key and parses its value with parse, returning def if the variable
+// isn't set or doesn't parse, e.g.
+//
+//	timeout := GetOr("TIMEOUT", 30*time.Second, ParseDuration)
+func GetOr[T any](key string, def T, parse func(string) (T, error)) T {
+	v, ok := Lookup(key)
+	if !ok {
+		return def
+	}
+	parsed, err := parse(v)
+	if err != nil {
+		return def
+	}
+	return parsed
+}
+
+// A parser for GetOr, same as time.ParseDuration.
+func ParseDuration(s string) (time.Duration, error) {
+	return time.ParseDuration(s)
+}
+
+// A parser for GetOr that splits a comma-separated list, trimming whitespace
+// around the elements. The empty string is an empty list.
+func ParseStringSlice(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	list := strings.Split(s, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list, nil
+}
+
+// How AddPrefix and ApplyPrefixed treat keys that already start with the
+// prefix.
+type PrefixMode int
+
+const (
+	SkipPrefixed PrefixMode = iota // Leave such keys as they are
+	PrefixAll                      // Prefix them again, e.g. APP_APP_NAME
+)
+
+// The inverse of stripping a namespace: a copy of m with prefix prepended to
+// every key. Keys that already start with prefix are left alone, unless mode
+// is PrefixAll.
+func AddPrefix(prefix string, m map[string]string, mode PrefixMode) map[string]string {
+	always := mode == PrefixAll
+	prefixed := make(map[string]string, len(m))
+	for k, v := range m {
+		if always || !strings.HasPrefix(k, prefix) {
+			k = prefix + k
+		}
+		prefixed[k] = v
+	}
+	return prefixed
+}
+
+// Sets the variables of m in the environment, under prefixed names as done by
+// AddPrefix. Stops at the first variable that can't be set.
+func ApplyPrefixed(prefix string, m map[string]string, mode PrefixMode) error {
+	prefixed := AddPrefix(prefix, m, mode)
+	for _, k := range sortedKeys(prefixed) {
+		if err := os.Setenv(k, prefixed[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compares two environments, such as two results of Map(): added has the
+// variables only in after, removed those only in before (with their old
+// values), and changed those whose value differs (with their new values).
+func Diff(before, after map[string]string) (added, removed, changed map[string]string) {
+	added, removed, changed = make(map[string]string), make(map[string]string), make(map[string]string)
+	for k, v := range after {
+		if old, ok := before[k]; !ok {
+			added[k] = v
+		} else if old != v {
+			changed[k] = v
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			removed[k] = v
+		}
+	}
+	return
+}
+
+// Runs fn and returns how it changed the environment, as by Diff. The
+// environment is left the way fn left it.
+func DiffEnv(fn func()) (added, removed, changed map[string]string) {
+	before := Map()
+	fn()
+	return Diff(before, Map())
+}
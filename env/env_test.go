@@ -2,8 +2,10 @@ package env
 
 import (
 	"fmt"
-	"testing"
 	"os"
+	"strings"
+	"testing"
+	"time"
 )
 
 func TestClearedEnv(t *testing.T) {
@@ -26,3 +28,457 @@ func ExampleMap() {
 		fmt.Printf("%s=%s\n", k, v)
 	}
 }
+
+func TestEnviron(t *testing.T) {
+	m := map[string]string{"B": "2", "A": "1", "EQ": "x=y=z", "EMPTY": ""}
+	got := Environ(m)
+	want := []string{"A=1", "B=2", "EMPTY=", "EQ=x=y=z"}
+	if len(got) != len(want) {
+		t.Fatalf("Environ() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Environ()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEnvironRoundTrip(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PLAIN", "value")
+	os.Setenv("WITH_EQUALS", "a=b=c")
+	os.Setenv("EMPTY", "")
+	env := Map()
+
+	for _, kv := range Environ(env) {
+		parts := strings.SplitN(kv, "=", 2)
+		if env[parts[0]] != parts[1] {
+			t.Errorf("%s: round-tripped to %q, want %q", parts[0], parts[1], env[parts[0]])
+		}
+	}
+	os.Clearenv()
+	for _, kv := range Environ(env) {
+		parts := strings.SplitN(kv, "=", 2)
+		os.Setenv(parts[0], parts[1])
+	}
+	again := Map()
+	if len(again) != len(env) {
+		t.Errorf("expected %d variables after round trip, got %d", len(env), len(again))
+	}
+	for k, v := range env {
+		if again[k] != v {
+			t.Errorf("%s = %q after round trip, want %q", k, again[k], v)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("SET_EMPTY", "")
+	os.Setenv("SET", "value")
+
+	if v, ok := Lookup("SET_EMPTY"); v != "" || !ok {
+		t.Errorf(`Lookup("SET_EMPTY") = %q, %v, want "", true`, v, ok)
+	}
+	if v, ok := Lookup("SET"); v != "value" || !ok {
+		t.Errorf(`Lookup("SET") = %q, %v, want "value", true`, v, ok)
+	}
+	if v, ok := Lookup("UNSET"); v != "" || ok {
+		t.Errorf(`Lookup("UNSET") = %q, %v, want "", false`, v, ok)
+	}
+	if !Has("SET_EMPTY") || Has("UNSET") {
+		t.Error("Has() doesn't tell set from unset")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("HOME", "/home/user")
+	os.Setenv("SHADOWED", "from process")
+
+	got := Expand(map[string]string{
+		"LOG_PATH": "${APP_DIR}/logs",
+		"APP_DIR":  "$HOME/${APP_NAME}",
+		"APP_NAME": "app",
+		"SHADOWED": "from map",
+		"USES":     "$SHADOWED",
+		"MISSING":  "[${NOT_SET}]",
+		"LITERAL":  "no references",
+	})
+	want := map[string]string{
+		"LOG_PATH": "/home/user/app/logs",
+		"APP_DIR":  "/home/user/app",
+		"APP_NAME": "app",
+		"SHADOWED": "from map",
+		"USES":     "from map",
+		"MISSING":  "[]",
+		"LITERAL":  "no references",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d keys, got %v", len(want), got)
+	}
+}
+
+func TestExpandCycle(t *testing.T) {
+	got := Expand(map[string]string{
+		"A":    "${B}",
+		"B":    "x${A}",
+		"SELF": "<$SELF>",
+		"P":    "${Q}",
+		"Q":    "${R}",
+		"R":    "-${P}",
+	})
+	if got["SELF"] != "<${SELF}>" {
+		t.Errorf("SELF = %q, want the reference left in place", got["SELF"])
+	}
+	// The same however the map is iterated
+	if got["A"] != "x${A}" || got["B"] != "x${B}" {
+		t.Errorf("A = %q, B = %q, want x${A} and x${B}", got["A"], got["B"])
+	}
+	if got["P"] != "-${P}" || got["Q"] != "-${Q}" || got["R"] != "-${R}" {
+		t.Errorf("P = %q, Q = %q, R = %q, want -${P}, -${Q} and -${R}", got["P"], got["Q"], got["R"])
+	}
+}
+
+func TestGetExpanded(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("HOME", "/home/user")
+	os.Setenv("APP_DIR", "${HOME}/app")
+	os.Setenv("CONFIG", "$HOME/app.conf")
+	os.Setenv("NESTED", "${APP_DIR}/${MISSING}x.conf")
+
+	if got := GetExpanded("CONFIG"); got != "/home/user/app.conf" {
+		t.Errorf(`GetExpanded("CONFIG") = %q, want "/home/user/app.conf"`, got)
+	}
+	// Only one level is expanded, and missing variables are empty
+	if got := GetExpanded("NESTED"); got != "${HOME}/app/x.conf" {
+		t.Errorf(`GetExpanded("NESTED") = %q, want "${HOME}/app/x.conf"`, got)
+	}
+	if got := GetExpanded("UNSET"); got != "" {
+		t.Errorf(`GetExpanded("UNSET") = %q, want ""`, got)
+	}
+	if got := GetExpandedOr("CONFIG", "default"); got != "/home/user/app.conf" {
+		t.Errorf(`GetExpandedOr("CONFIG") = %q, want "/home/user/app.conf"`, got)
+	}
+	if got := GetExpandedOr("UNSET", "$HOME"); got != "$HOME" {
+		t.Errorf(`GetExpandedOr("UNSET") = %q, want the default unexpanded`, got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := map[string]string{"A": "base", "B": "base"}
+	overlay := map[string]string{"B": "overlay", "C": "overlay"}
+
+	tests := []struct {
+		overwrite bool
+		want      map[string]string
+	}{
+		{false, map[string]string{"A": "base", "B": "base", "C": "overlay"}},
+		{true, map[string]string{"A": "base", "B": "overlay", "C": "overlay"}},
+	}
+	for _, test := range tests {
+		got := Merge(base, overlay, test.overwrite)
+		if len(got) != len(test.want) {
+			t.Errorf("overwrite=%v: got %v, want %v", test.overwrite, got, test.want)
+		}
+		for k, v := range test.want {
+			if got[k] != v {
+				t.Errorf("overwrite=%v: %s = %q, want %q", test.overwrite, k, got[k], v)
+			}
+		}
+	}
+	if base["B"] != "base" || len(base) != 2 {
+		t.Errorf("Merge() modified its input: %v", base)
+	}
+}
+
+func TestMergeAll(t *testing.T) {
+	defaults := map[string]string{"A": "default", "B": "default", "C": "default"}
+	dotenv := map[string]string{"B": "dotenv", "C": "dotenv"}
+	process := map[string]string{"C": "process"}
+
+	got := MergeAll(defaults, dotenv, process)
+	want := map[string]string{"A": "default", "B": "dotenv", "C": "process"}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if len(MergeAll()) != 0 {
+		t.Error("MergeAll() of nothing isn't empty")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("A", "1")
+	os.Setenv("EMPTY", "")
+
+	if err := Require("A", "EMPTY"); err != nil {
+		t.Errorf("Require() with everything set: %v", err)
+	}
+	err := Require("A", "MISSING_1", "EMPTY", "MISSING_2")
+	if err == nil {
+		t.Fatal("Require() didn't fail for missing variables")
+	}
+	for _, k := range []string{"MISSING_1", "MISSING_2"} {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("error %q doesn't name %s", err, k)
+		}
+	}
+	if strings.Contains(err.Error(), "EMPTY") {
+		t.Errorf("error %q names a variable that is set", err)
+	}
+}
+
+func TestMustGet(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("A", "1")
+	if v := MustGet("A"); v != "1" {
+		t.Errorf(`MustGet("A") = %q, want "1"`, v)
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("MustGet() of an unset variable didn't panic")
+		}
+		if msg, ok := r.(string); !ok || !strings.Contains(msg, "NOT_SET") {
+			t.Errorf("panic %v doesn't name the variable", r)
+		}
+	}()
+	MustGet("NOT_SET")
+}
+
+func TestKeysAndSortedPairs(t *testing.T) {
+	os.Clearenv()
+	for _, k := range []string{"ZED", "ALPHA", "MIKE", "BRAVO"} {
+		os.Setenv(k, strings.ToLower(k))
+	}
+	env := Map()
+
+	keys := Keys()
+	pairs := SortedPairs()
+	if len(keys) != len(env) || len(pairs) != len(env) {
+		t.Fatalf("expected %d keys and pairs, got %v and %v", len(env), keys, pairs)
+	}
+	for i := range keys {
+		if i > 0 && keys[i-1] >= keys[i] {
+			t.Errorf("keys not sorted: %v", keys)
+		}
+		if _, ok := env[keys[i]]; !ok {
+			t.Errorf("key %s isn't in Map()", keys[i])
+		}
+		if pairs[i].Key != keys[i] || pairs[i].Value != env[keys[i]] {
+			t.Errorf("pair %d = %v, want {%s %s}", i, pairs[i], keys[i], env[keys[i]])
+		}
+	}
+}
+
+func TestMapFunc(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("APP_NAME", "  demo  ")
+	os.Setenv("APP_PORT", "8080")
+	os.Setenv("OTHER", "dropped")
+	os.Setenv("lower", "renamed")
+
+	got := MapFunc(func(key, val string) (string, string, bool) {
+		switch {
+		case strings.HasPrefix(key, "APP_"):
+			return strings.TrimPrefix(key, "APP_"), strings.TrimSpace(val), true
+		case key == strings.ToLower(key):
+			return strings.ToUpper(key), val, true
+		}
+		return "", "", false
+	})
+	want := map[string]string{"NAME": "demo", "PORT": "8080", "LOWER": "renamed"}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestClearPrefix(t *testing.T) {
+	os.Clearenv()
+	for _, k := range []string{"APP_A", "APP_B", "APP_", "APPLE", "OTHER"} {
+		os.Setenv(k, "x")
+	}
+
+	if n := ClearPrefix("APP_"); n != 3 {
+		t.Errorf("ClearPrefix() = %d, want 3", n)
+	}
+	for _, k := range []string{"APP_A", "APP_B", "APP_"} {
+		if Has(k) {
+			t.Errorf("%s still set", k)
+		}
+	}
+	for _, k := range []string{"APPLE", "OTHER"} {
+		if !Has(k) {
+			t.Errorf("%s was removed", k)
+		}
+	}
+	if n := ClearPrefix("APP_"); n != 0 {
+		t.Errorf("second ClearPrefix() = %d, want 0", n)
+	}
+}
+
+func TestSubset(t *testing.T) {
+	m := map[string]string{"A": "1", "B": "2", "C": "3"}
+	got := Subset(m, "A", "C", "MISSING")
+	if len(got) != 2 || got["A"] != "1" || got["C"] != "3" {
+		t.Errorf("Subset() = %v, want A and C", got)
+	}
+	got["A"] = "changed"
+	if m["A"] != "1" || len(m) != 3 {
+		t.Errorf("Subset() shares or modified its input: %v", m)
+	}
+}
+
+func TestWithout(t *testing.T) {
+	m := map[string]string{"A": "1", "B": "2", "C": "3"}
+	got := Without(m, "B", "MISSING")
+	if len(got) != 2 || got["A"] != "1" || got["C"] != "3" {
+		t.Errorf("Without() = %v, want A and C", got)
+	}
+	if len(m) != 3 || m["B"] != "2" {
+		t.Errorf("Without() modified its input: %v", m)
+	}
+}
+
+func TestGetOr(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("TIMEOUT", "1m30s")
+	os.Setenv("BAD_TIMEOUT", "soon")
+	os.Setenv("HOSTS", "a, b ,c")
+	os.Setenv("CSV", "1;2")
+
+	def := 5 * time.Second
+	if got := GetOr("TIMEOUT", def, time.ParseDuration); got != 90*time.Second {
+		t.Errorf(`GetOr("TIMEOUT") = %s, want 1m30s`, got)
+	}
+	if got := GetOr("BAD_TIMEOUT", def, ParseDuration); got != def {
+		t.Errorf(`GetOr("BAD_TIMEOUT") = %s, want the default`, got)
+	}
+	if got := GetOr("UNSET", def, ParseDuration); got != def {
+		t.Errorf(`GetOr("UNSET") = %s, want the default`, got)
+	}
+
+	if got := GetOr("HOSTS", nil, ParseStringSlice); strings.Join(got, "|") != "a|b|c" {
+		t.Errorf(`GetOr("HOSTS") = %q, want [a b c]`, got)
+	}
+	semicolons := func(s string) ([]string, error) {
+		return strings.Split(s, ";"), nil
+	}
+	if got := GetOr("CSV", []string{"default"}, semicolons); strings.Join(got, "|") != "1|2" {
+		t.Errorf(`GetOr("CSV") = %q, want [1 2]`, got)
+	}
+	if got := GetOr("UNSET", []string{"default"}, ParseStringSlice); len(got) != 1 || got[0] != "default" {
+		t.Errorf(`GetOr("UNSET") = %q, want the default`, got)
+	}
+}
+
+func TestAddPrefix(t *testing.T) {
+	m := map[string]string{"NAME": "app", "APP_PORT": "80"}
+	got := AddPrefix("APP_", m, SkipPrefixed)
+	if len(got) != 2 || got["APP_NAME"] != "app" || got["APP_PORT"] != "80" {
+		t.Errorf("AddPrefix(SkipPrefixed) = %v, want APP_NAME and APP_PORT", got)
+	}
+	got = AddPrefix("APP_", m, PrefixAll)
+	if len(got) != 2 || got["APP_NAME"] != "app" || got["APP_APP_PORT"] != "80" {
+		t.Errorf("AddPrefix(PrefixAll) = %v, want APP_NAME and APP_APP_PORT", got)
+	}
+	if len(m) != 2 || m["NAME"] != "app" {
+		t.Errorf("AddPrefix() modified its input: %v", m)
+	}
+}
+
+func TestApplyPrefixed(t *testing.T) {
+	os.Clearenv()
+	m := map[string]string{"NAME": "app", "APP_PORT": "80"}
+	if err := ApplyPrefixed("APP_", m, SkipPrefixed); err != nil {
+		t.Fatal(err)
+	}
+	if got := Map(); len(got) != 2 || got["APP_NAME"] != "app" || got["APP_PORT"] != "80" {
+		t.Errorf("environment after ApplyPrefixed(SkipPrefixed) = %v, want APP_NAME and APP_PORT", got)
+	}
+
+	os.Clearenv()
+	if err := ApplyPrefixed("APP_", m, PrefixAll); err != nil {
+		t.Fatal(err)
+	}
+	if got := Map(); len(got) != 2 || got["APP_APP_PORT"] != "80" {
+		t.Errorf("environment after ApplyPrefixed(PrefixAll) = %v, want APP_NAME and APP_APP_PORT", got)
+	}
+	if err := ApplyPrefixed("BAD=", m, SkipPrefixed); err == nil {
+		t.Error("expected an error for a prefix that makes invalid names")
+	}
+}
+
+func TestDiffEnv(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("KEEP", "same")
+	os.Setenv("CHANGE", "old")
+	os.Setenv("REMOVE", "gone")
+
+	added, removed, changed := DiffEnv(func() {
+		os.Setenv("ADD", "new")
+		os.Setenv("CHANGE", "new")
+		os.Setenv("KEEP", "same")
+		os.Unsetenv("REMOVE")
+	})
+	if len(added) != 1 || added["ADD"] != "new" {
+		t.Errorf("added = %v, want ADD=new", added)
+	}
+	if len(removed) != 1 || removed["REMOVE"] != "gone" {
+		t.Errorf("removed = %v, want REMOVE=gone", removed)
+	}
+	if len(changed) != 1 || changed["CHANGE"] != "new" {
+		t.Errorf("changed = %v, want CHANGE=new", changed)
+	}
+	if os.Getenv("CHANGE") != "new" || Has("REMOVE") {
+		t.Errorf("environment not left as fn left it: %v", Map())
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	os.Clearenv()
+	for _, k := range []string{"ZED", "ALPHA", "MIDDLE", "EMPTY"} {
+		os.Setenv(k, strings.ToLower(k))
+	}
+	os.Setenv("EMPTY", "")
+
+	o := OrderedMap()
+	environ := os.Environ()
+	if len(o) != len(environ) {
+		t.Fatalf("OrderedMap() = %v, want the entries of %v", o, environ)
+	}
+	for i, p := range o {
+		if p.Key+"="+p.Value != environ[i] {
+			t.Errorf("OrderedMap()[%d] = %v, want %q", i, p, environ[i])
+		}
+	}
+	if v, ok := o.Get("MIDDLE"); !ok || v != "middle" {
+		t.Errorf(`Get("MIDDLE") = %q, %v, want "middle", true`, v, ok)
+	}
+	if v, ok := o.Get("EMPTY"); !ok || v != "" {
+		t.Errorf(`Get("EMPTY") = %q, %v, want "", true`, v, ok)
+	}
+	if _, ok := o.Get("UNSET"); ok {
+		t.Error(`Get("UNSET") found a variable that isn't set`)
+	}
+	if m := o.Map(); len(m) != 4 || m["ZED"] != "zed" {
+		t.Errorf("Map() = %v, want the four variables", m)
+	}
+}
@@ -0,0 +1,124 @@
+package env
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A copy of m with only the keys starting with prefix.
+func FilterPrefix(m map[string]string, prefix string) map[string]string {
+	filtered := make(map[string]string)
+	for k, v := range m {
+		if strings.HasPrefix(k, prefix) {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+// A copy of m with prefix removed from the keys that start with it. Other keys
+// are kept as they are.
+func StripPrefix(m map[string]string, prefix string) map[string]string {
+	stripped := make(map[string]string, len(m))
+	for k, v := range m {
+		stripped[strings.TrimPrefix(k, prefix)] = v
+	}
+	return stripped
+}
+
+// The names of the variables in the environment that match pattern, as by
+// filepath.Match (so "*_SECRET" or "DB_?"), sorted. The only error is
+// filepath.ErrBadPattern.
+func KeysMatching(pattern string) ([]string, error) {
+	m, err := MapMatching(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(m), nil
+}
+
+// The names of the variables in the environment that re matches, sorted. The
+// match may be anywhere in the name, unless re is anchored.
+func KeysMatchingRegexp(re *regexp.Regexp) []string {
+	var keys []string
+	for _, k := range Keys() {
+		if re.MatchString(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// The variables in the environment whose names match pattern, as by
+// KeysMatching.
+func MapMatching(pattern string) (map[string]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return MapFunc(func(key, val string) (string, string, bool) {
+		ok, _ := filepath.Match(pattern, key)
+		return key, val, ok
+	}), nil
+}
+
+// Chains operations on a map of variables, e.g.
+//
+//	From(Map()).FilterPrefix("APP_").StripPrefix("APP_").Expand().Map()
+//
+// Each step is one of the functions of the same name, and returns the
+// Transform for the next; Map() or Apply() end the chain.
+type Transform struct {
+	m map[string]string
+}
+
+// Starts a chain with m, which isn't modified by it.
+func From(m map[string]string) *Transform {
+	return &Transform{m}
+}
+
+func (t *Transform) FilterPrefix(prefix string) *Transform {
+	t.m = FilterPrefix(t.m, prefix)
+	return t
+}
+
+func (t *Transform) StripPrefix(prefix string) *Transform {
+	t.m = StripPrefix(t.m, prefix)
+	return t
+}
+
+func (t *Transform) AddPrefix(prefix string, mode PrefixMode) *Transform {
+	t.m = AddPrefix(prefix, t.m, mode)
+	return t
+}
+
+func (t *Transform) Expand() *Transform {
+	t.m = Expand(t.m)
+	return t
+}
+
+// Adds the variables of overlay, which take precedence, as with MergeAll.
+func (t *Transform) Merge(overlay map[string]string) *Transform {
+	t.m = MergeAll(t.m, overlay)
+	return t
+}
+
+func (t *Transform) Subset(keys ...string) *Transform {
+	t.m = Subset(t.m, keys...)
+	return t
+}
+
+func (t *Transform) Without(keys ...string) *Transform {
+	t.m = Without(t.m, keys...)
+	return t
+}
+
+// The resulting map.
+func (t *Transform) Map() map[string]string {
+	return MergeAll(t.m)
+}
+
+// Sets the resulting variables in the environment, see Apply.
+func (t *Transform) Apply() error {
+	return Apply(t.m)
+}
@@ -0,0 +1,97 @@
+package env
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFilterAndStripPrefix(t *testing.T) {
+	m := map[string]string{"APP_NAME": "app", "APP_PORT": "80", "HOME": "/home"}
+	filtered := FilterPrefix(m, "APP_")
+	if len(filtered) != 2 || filtered["APP_NAME"] != "app" || filtered["APP_PORT"] != "80" {
+		t.Errorf("FilterPrefix() = %v, want the APP_ variables", filtered)
+	}
+	stripped := StripPrefix(m, "APP_")
+	if len(stripped) != 3 || stripped["NAME"] != "app" || stripped["HOME"] != "/home" {
+		t.Errorf("StripPrefix() = %v, want NAME, PORT and HOME", stripped)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("HOME", "/home/user")
+	m := map[string]string{
+		"APP_DIR":    "${HOME}/app",
+		"APP_CONFIG": "${APP_DIR}/app.conf",
+		"APP_DEBUG":  "true",
+		"OTHER":      "x",
+	}
+
+	got := From(m).FilterPrefix("APP_").StripPrefix("APP_").Without("DEBUG").Expand().Map()
+	want := Expand(Without(StripPrefix(FilterPrefix(m, "APP_"), "APP_"), "DEBUG"))
+	if len(got) != len(want) || len(got) != 2 {
+		t.Fatalf("chain gave %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("chain gave %s=%q, want %q", k, got[k], v)
+		}
+	}
+	// Neither ${DIR} nor ${APP_DIR} is there anymore when expanding
+	if got["DIR"] != "/home/user/app" || got["CONFIG"] != "/app.conf" {
+		t.Errorf("unexpected expansion: %v", got)
+	}
+	if len(m) != 4 || m["APP_DIR"] != "${HOME}/app" {
+		t.Errorf("chain modified its input: %v", m)
+	}
+
+	if err := From(m).Subset("OTHER").AddPrefix("MY_", SkipPrefixed).Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("MY_OTHER") != "x" {
+		t.Errorf("Apply() didn't set MY_OTHER: %v", Map())
+	}
+}
+
+func TestKeysMatching(t *testing.T) {
+	os.Clearenv()
+	for _, k := range []string{"DB_SECRET", "API_SECRET", "SECRET_KEY", "DB_1", "DB_10"} {
+		os.Setenv(k, strings.ToLower(k))
+	}
+
+	keys, err := KeysMatching("*_SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "API_SECRET,DB_SECRET" {
+		t.Errorf(`KeysMatching("*_SECRET") = %v, want [API_SECRET DB_SECRET]`, keys)
+	}
+	if keys, _ := KeysMatching("DB_?"); strings.Join(keys, ",") != "DB_1" {
+		t.Errorf(`KeysMatching("DB_?") = %v, want [DB_1]`, keys)
+	}
+	if _, err := KeysMatching("DB_["); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+	if _, err := MapMatching("DB_["); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+
+	keys = KeysMatchingRegexp(regexp.MustCompile(`^DB_\d+$`))
+	if strings.Join(keys, ",") != "DB_1,DB_10" {
+		t.Errorf("KeysMatchingRegexp(%s) = %v, want [DB_1 DB_10]", `^DB_\d+$`, keys)
+	}
+	keys = KeysMatchingRegexp(regexp.MustCompile(`SECRET`))
+	if len(keys) != 3 {
+		t.Errorf("KeysMatchingRegexp(SECRET) = %v, want all three secrets", keys)
+	}
+
+	m, err := MapMatching("SECRET_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || m["SECRET_KEY"] != "secret_key" {
+		t.Errorf(`MapMatching("SECRET_*") = %v, want SECRET_KEY`, m)
+	}
+}
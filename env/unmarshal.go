@@ -0,0 +1,128 @@
+package env
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sets the fields of the struct v points to from the environment. A field is
+// read from the variable named by its `env` tag, and left alone if that
+// variable isn't set; fields without the tag are skipped.
+//
+// Besides strings, bools, numbers and time.Durations, a []string field is
+// read as a list and a map[string]string field as k=v pairs, split on commas
+// or on the field's `delim` tag. Whitespace around elements is trimmed:
+//
+//	type Config struct {
+//		Hosts  []string          `env:"HOSTS"`             // HOSTS=a,b,c
+//		Labels map[string]string `env:"LABELS"`            // LABELS=env=prod,team=core
+//		Path   []string          `env:"PATH" delim:":"`
+//	}
+func Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Unmarshal needs a pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	typ := rv.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		key := f.Tag.Get("env")
+		if key == "" || !rv.Field(i).CanSet() {
+			continue
+		}
+		s, ok := Lookup(key)
+		if !ok {
+			continue
+		}
+		delim := f.Tag.Get("delim")
+		if delim == "" {
+			delim = ","
+		}
+		if err := setField(rv.Field(i), s, delim); err != nil {
+			return fmt.Errorf("Cannot set %s from %s=%q: %v", f.Name, key, s, err)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setField(field reflect.Value, s, delim string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, elem := range split(s, delim) {
+			list = reflect.Append(list, reflect.ValueOf(elem).Convert(field.Type().Elem()))
+		}
+		field.Set(list)
+		return nil
+	case field.Kind() == reflect.Map && field.Type().Key().Kind() == reflect.String &&
+		field.Type().Elem().Kind() == reflect.String:
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range split(s, delim) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("Expected key=value, got %q", pair)
+			}
+			k := reflect.ValueOf(strings.TrimSpace(kv[0])).Convert(field.Type().Key())
+			m.SetMapIndex(k, reflect.ValueOf(strings.TrimSpace(kv[1])).Convert(field.Type().Elem()))
+		}
+		field.Set(m)
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(n)
+	default:
+		return fmt.Errorf("Unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// Splits a delimited list, trimming whitespace around the elements and
+// dropping empty ones.
+func split(s, delim string) []string {
+	var list []string
+	for _, elem := range strings.Split(s, delim) {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
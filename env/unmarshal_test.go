@@ -0,0 +1,72 @@
+package env
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmarshal(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("NAME", "app")
+	os.Setenv("PORT", "8080")
+	os.Setenv("DEBUG", "true")
+	os.Setenv("TIMEOUT", "2s")
+	os.Setenv("HOSTS", " a, b ,c ")
+	os.Setenv("LABELS", "env=prod, team = core")
+	os.Setenv("SEARCH", "/usr/bin;/bin")
+
+	var cfg struct {
+		Name     string            `env:"NAME"`
+		Port     int               `env:"PORT"`
+		Debug    bool              `env:"DEBUG"`
+		Timeout  time.Duration     `env:"TIMEOUT"`
+		Hosts    []string          `env:"HOSTS"`
+		Labels   map[string]string `env:"LABELS"`
+		Search   []string          `env:"SEARCH" delim:";"`
+		Unset    string            `env:"UNSET"`
+		Untagged string
+	}
+	cfg.Unset = "default"
+	if err := Unmarshal(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "app" || cfg.Port != 8080 || !cfg.Debug || cfg.Timeout != 2*time.Second {
+		t.Errorf("scalar fields not set: %+v", cfg)
+	}
+	if strings.Join(cfg.Hosts, "|") != "a|b|c" {
+		t.Errorf("Hosts = %q, want [a b c]", cfg.Hosts)
+	}
+	if len(cfg.Labels) != 2 || cfg.Labels["env"] != "prod" || cfg.Labels["team"] != "core" {
+		t.Errorf("Labels = %v, want env=prod and team=core", cfg.Labels)
+	}
+	if strings.Join(cfg.Search, "|") != "/usr/bin|/bin" {
+		t.Errorf("Search = %q, want [/usr/bin /bin]", cfg.Search)
+	}
+	if cfg.Unset != "default" {
+		t.Errorf("unset variable overwrote field: %q", cfg.Unset)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("PORT", "eighty")
+	os.Setenv("LABELS", "env")
+
+	var port struct {
+		Port int `env:"PORT"`
+	}
+	if err := Unmarshal(&port); err == nil {
+		t.Error("expected an error for a malformed number")
+	}
+	var labels struct {
+		Labels map[string]string `env:"LABELS"`
+	}
+	if err := Unmarshal(&labels); err == nil {
+		t.Error("expected an error for a pair without =")
+	}
+	if err := Unmarshal(port); err == nil {
+		t.Error("expected an error for a non-pointer")
+	}
+}